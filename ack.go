@@ -0,0 +1,252 @@
+package lp
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ackCheckInterval is how often the unacknowledged events are checked
+const ackCheckInterval = time.Second
+
+// inflightEvent is a delivered event waiting to be acknowledged
+type inflightEvent struct {
+	ev       *Event
+	deadline time.Time
+}
+
+// track moves the delivered events in the in-flight set.
+// It must be called holding the subscription lock.
+func (s *Subscription) track(events []*Event) {
+	timeout := s.opts.AckTimeout
+	if timeout == 0 {
+		timeout = s.defaults.AckTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for _, e := range events {
+		// Control events are not numbered and can not be acknowledged
+		if e.seq == 0 {
+			continue
+		}
+		s.inflight[e.seq] = &inflightEvent{e, deadline}
+	}
+}
+
+// Ack acknowledges delivered events, that will not be delivered again. It
+// returns the number of acknowledged events.
+func (s *Subscription) Ack(ids ...uint64) int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	acked := 0
+	for _, id := range ids {
+		if _, exists := s.inflight[id]; exists {
+			delete(s.inflight, id)
+			acked++
+		}
+	}
+	return acked
+}
+
+// Nack rejects delivered events, that are delivered again after delay
+// (immediately if delay is zero). It returns the number of rejected events.
+func (s *Subscription) Nack(delay time.Duration, ids ...uint64) int {
+	s.l.Lock()
+	nacked := 0
+	deadline := time.Now().Add(delay)
+	for _, id := range ids {
+		if inflight, exists := s.inflight[id]; exists {
+			inflight.deadline = deadline
+			nacked++
+		}
+	}
+	s.l.Unlock()
+
+	if delay == 0 {
+		s.redeliver(time.Now())
+	}
+	return nacked
+}
+
+// redeliver queues again, in front of the queue, the in-flight events not
+// acknowledged before their deadline. The queue limit and the overflow
+// policy apply to them as to the new events.
+func (s *Subscription) redeliver(now time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	// A terminated subscription waits to be closed
+	if s.overflowed {
+		return
+	}
+
+	expired := make([]*Event, 0)
+	for id, inflight := range s.inflight {
+		if !inflight.deadline.After(now) {
+			expired = append(expired, inflight.ev)
+			delete(s.inflight, id)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].seq < expired[j].seq })
+
+	queued := s.events
+	s.events = make([]*Event, 0, len(expired)+len(queued))
+	for _, e := range append(expired, queued...) {
+		if !s.enqueue(e) {
+			s.terminate(stateOverflow)
+			return
+		}
+	}
+	s.signal(stateReady)
+}
+
+// startRedeliverer starts the redeliverer, if it is not running yet. It is
+// started by the first subscription in ack mode.
+// It must be called holding the broker lock.
+func (b *Broker) startRedeliverer() {
+	if b.redelivering {
+		return
+	}
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	b.redelivering = true
+	b.wg.Add(1)
+	go b.redeliverer()
+}
+
+// redeliverer periodically queues again the unacknowledged events
+func (b *Broker) redeliverer() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(ackCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.l.Lock()
+			subscriptions := make([]*Subscription, 0)
+			for _, s := range b.subscriptions {
+				if s.opts.Ack {
+					subscriptions = append(subscriptions, s)
+				}
+			}
+			b.l.Unlock()
+
+			for _, s := range subscriptions {
+				s.redeliver(now)
+			}
+		}
+	}
+}
+
+// AckHandler acknowledges the delivered events (id parameters) of a
+// subscription in ack mode
+func (b *Broker) AckHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	if !b.authorize(w, r, ActionListen, subscriptionFeeds(subscription)) {
+		return
+	}
+
+	ids, err := extractIDs(r, "id")
+	if err != nil || len(ids) == 0 {
+		b.sendError(w, r, 400, "missing valid id(s)")
+		return
+	}
+
+	resp := struct {
+		Error bool
+		Acked int
+	}{
+		false,
+		subscription.Ack(ids...),
+	}
+	SendResponse(w, resp)
+	return
+}
+
+// NackHandler rejects the delivered events (id parameters) of a
+// subscription in ack mode. They are delivered again after delay
+// milliseconds (immediately by default).
+func (b *Broker) NackHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	if !b.authorize(w, r, ActionListen, subscriptionFeeds(subscription)) {
+		return
+	}
+
+	ids, err := extractIDs(r, "id")
+	if err != nil || len(ids) == 0 {
+		b.sendError(w, r, 400, "missing valid id(s)")
+		return
+	}
+
+	var delay time.Duration
+	if delayString := r.URL.Query().Get("delay"); delayString != "" {
+		ms, err := strconv.Atoi(delayString)
+		if err != nil || ms < 0 {
+			b.sendError(w, r, 400, "not valid delay")
+			return
+		}
+		delay = time.Duration(ms) * time.Millisecond
+	}
+
+	resp := struct {
+		Error  bool
+		Nacked int
+	}{
+		false,
+		subscription.Nack(delay, ids...),
+	}
+	SendResponse(w, resp)
+	return
+}
+
+// extractIDs returns the event ids of a parameter
+func extractIDs(r *http.Request, name string) ([]uint64, error) {
+	ids := make([]uint64, 0)
+	for _, idString := range r.URL.Query()[name] {
+		id, err := strconv.ParseUint(idString, 10, 64)
+		if err != nil {
+			return ids, errors.New("not valid " + name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
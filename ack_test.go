@@ -0,0 +1,113 @@
+package lp
+
+import (
+	"testing"
+	"time"
+)
+
+func newAckSubscription(t *testing.T, opts SubscriptionOptions) (*Broker, *Feed, *Subscription) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	t.Cleanup(func() { b.Close() })
+
+	feed, err := b.NewFeed("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Ack = true
+	s := b.NewSubscriptionWithOptions(opts)
+	s.Subscribe(feed)
+	return b, feed, s
+}
+
+func eventSeqs(events []*Event) []uint64 {
+	seqs := make([]uint64, 0, len(events))
+	for _, e := range events {
+		seqs = append(seqs, e.seq)
+	}
+	return seqs
+}
+
+func TestAckRedelivery(t *testing.T) {
+	b, feed, s := newAckSubscription(t, SubscriptionOptions{AckTimeout: time.Minute})
+	for i := 0; i < 3; i++ {
+		b.NewEvent(feed, i)
+	}
+
+	delivered, _ := s.takeEvents(0, 0)
+	if len(delivered) != 3 {
+		t.Fatalf("%d events, want 3", len(delivered))
+	}
+	if acked := s.Ack(delivered[1].seq); acked != 1 {
+		t.Fatalf("%d acked, want 1", acked)
+	}
+
+	// Nothing is delivered again before the deadline
+	s.redeliver(time.Now())
+	if events, _ := s.takeEvents(0, 0); len(events) != 0 {
+		t.Fatalf("redelivered %v before the deadline", eventSeqs(events))
+	}
+
+	// The unacknowledged events are delivered again, in order
+	s.redeliver(time.Now().Add(2 * time.Minute))
+	events, _ := s.takeEvents(0, 0)
+	want := []uint64{delivered[0].seq, delivered[2].seq}
+	if seqs := eventSeqs(events); !equalSeqs(seqs, want) {
+		t.Fatalf("redelivered %v, want %v", seqs, want)
+	}
+
+	s.Ack(want...)
+	s.redeliver(time.Now().Add(time.Hour))
+	if events, _ := s.takeEvents(0, 0); len(events) != 0 {
+		t.Fatalf("redelivered acknowledged events %v", eventSeqs(events))
+	}
+}
+
+func TestNackRedelivery(t *testing.T) {
+	b, feed, s := newAckSubscription(t, SubscriptionOptions{AckTimeout: time.Minute})
+	b.NewEvent(feed, 1)
+	b.NewEvent(feed, 2)
+	delivered, _ := s.takeEvents(0, 0)
+
+	// A new event is queued before the nack: the rejected event goes first
+	b.NewEvent(feed, 3)
+	if nacked := s.Nack(0, delivered[1].seq); nacked != 1 {
+		t.Fatalf("%d nacked, want 1", nacked)
+	}
+	events, _ := s.takeEvents(0, 0)
+	if len(events) != 2 || events[0].seq != delivered[1].seq {
+		t.Fatalf("queued %v, want the rejected event first", eventSeqs(events))
+	}
+
+	if nacked := s.Nack(0, 12345); nacked != 0 {
+		t.Fatalf("%d nacked, want 0 for an unknown id", nacked)
+	}
+}
+
+func TestRedeliveryQueueLimit(t *testing.T) {
+	b, feed, s := newAckSubscription(t, SubscriptionOptions{MaxQueue: 2, Overflow: OverflowDropNewest})
+	b.NewEvent(feed, 1)
+	b.NewEvent(feed, 2)
+	delivered, _ := s.takeEvents(0, 0)
+	b.NewEvent(feed, 3)
+	b.NewEvent(feed, 4)
+
+	s.Nack(0, eventSeqs(delivered)...)
+	events, dropped := s.takeEvents(0, 0)
+	if seqs := eventSeqs(events); !equalSeqs(seqs, eventSeqs(delivered)) || dropped != 2 {
+		t.Fatalf("queued %v, dropped %d, want %v and 2 dropped", seqs, dropped, eventSeqs(delivered))
+	}
+}
+
+func TestRedelivererStartsWithAck(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	b.NewSubscription()
+	if b.redelivering {
+		t.Fatal("redeliverer started without ack subscriptions")
+	}
+	b.NewSubscriptionWithOptions(SubscriptionOptions{Ack: true})
+	if !b.redelivering {
+		t.Fatal("redeliverer not started")
+	}
+}
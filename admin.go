@@ -0,0 +1,195 @@
+package lp
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	adminDefaultLimit = 100
+	adminMaxLimit     = 1000
+)
+
+// FeedInfo is the exported admin reppresentation of a feed
+type FeedInfo struct {
+	Name        string
+	ID          string
+	Subscribers int
+	Published   int64
+	LastPublish time.Time
+}
+
+// SubscriptionInfo is the exported admin reppresentation of a subscription
+type SubscriptionInfo struct {
+	ID        string
+	Owner     string `json:",omitempty"`
+	Feeds     []string
+	Patterns  []string `json:",omitempty"`
+	Queued    int
+	InFlight  int
+	Listening bool
+	LastSeen  time.Time
+}
+
+// Info returns the feed admin informations
+func (f *Feed) Info() FeedInfo {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return FeedInfo{
+		Name:        f.name,
+		ID:          string(f.id),
+		Subscribers: len(f.subscriptions),
+		Published:   f.published,
+		LastPublish: f.lastPublish,
+	}
+}
+
+// Info returns the subscription admin informations
+func (s *Subscription) Info() SubscriptionInfo {
+	feeds := s.FeedNames()
+	patterns := s.Patterns()
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return SubscriptionInfo{
+		ID:        string(s.id),
+		Owner:     s.opts.Owner,
+		Feeds:     feeds,
+		Patterns:  patterns,
+		Queued:    len(s.events),
+		InFlight:  len(s.inflight),
+		Listening: s.listener != nil,
+		LastSeen:  s.lastSeen,
+	}
+}
+
+// FeedsInfo returns the admin informations of all the feeds, sorted by name
+func (b *Broker) FeedsInfo() []FeedInfo {
+	b.l.Lock()
+	feeds := make([]*Feed, 0, len(b.feeds))
+	for _, f := range b.feeds {
+		feeds = append(feeds, f)
+	}
+	b.l.Unlock()
+
+	infos := make([]FeedInfo, 0, len(feeds))
+	for _, f := range feeds {
+		infos = append(infos, f.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// SubscriptionsInfo returns the admin informations of all the
+// subscriptions, sorted by id
+func (b *Broker) SubscriptionsInfo() []SubscriptionInfo {
+	b.l.Lock()
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.l.Unlock()
+
+	infos := make([]SubscriptionInfo, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// AdminFeedsHandler lists the feeds. It accepts offset and limit parameters.
+func (b *Broker) AdminFeedsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	if !b.authorize(w, r, ActionAdmin, nil) {
+		return
+	}
+
+	infos := b.FeedsInfo()
+	offset, limit := extractPage(r, len(infos))
+
+	resp := struct {
+		Error  bool
+		Total  int
+		Offset int
+		Limit  int
+		Feeds  []FeedInfo
+	}{
+		false,
+		len(infos),
+		offset,
+		limit,
+		infos[offset:pageEnd(offset, limit, len(infos))],
+	}
+	SendResponse(w, resp)
+	return
+}
+
+// AdminSubscriptionsHandler lists the subscriptions. It accepts offset and
+// limit parameters.
+func (b *Broker) AdminSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	if !b.authorize(w, r, ActionAdmin, nil) {
+		return
+	}
+
+	infos := b.SubscriptionsInfo()
+	offset, limit := extractPage(r, len(infos))
+
+	resp := struct {
+		Error         bool
+		Total         int
+		Offset        int
+		Limit         int
+		Subscriptions []SubscriptionInfo
+	}{
+		false,
+		len(infos),
+		offset,
+		limit,
+		infos[offset:pageEnd(offset, limit, len(infos))],
+	}
+	SendResponse(w, resp)
+	return
+}
+
+// extractPage returns the offset and limit parameters, bound to the total
+// number of items
+func extractPage(r *http.Request, total int) (offset int, limit int) {
+	query := r.URL.Query()
+
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+
+	limit, err = strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = adminDefaultLimit
+	}
+	if limit > adminMaxLimit {
+		limit = adminMaxLimit
+	}
+	return offset, limit
+}
+
+// pageEnd returns the end index of a page
+func pageEnd(offset int, limit int, total int) int {
+	if offset+limit > total {
+		return total
+	}
+	return offset + limit
+}
@@ -0,0 +1,81 @@
+package lp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminFeedsHandler(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	for _, name := range []string{"c", "a", "b"} {
+		b.NewFeed(name)
+	}
+	feed, _ := b.GetFeedFromName("a")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	b.NewEvent(feed, 1)
+
+	w := httptest.NewRecorder()
+	b.AdminFeedsHandler(w, httptest.NewRequest("GET", "/admin/feeds?offset=0&limit=2", nil))
+	var resp struct {
+		Total int
+		Limit int
+		Feeds []FeedInfo
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 3 || resp.Limit != 2 || len(resp.Feeds) != 2 {
+		t.Fatalf("total %d, limit %d, %d feeds", resp.Total, resp.Limit, len(resp.Feeds))
+	}
+	if info := resp.Feeds[0]; info.Name != "a" || info.Subscribers != 1 || info.Published != 1 {
+		t.Fatalf("feed info %+v", info)
+	}
+	if resp.Feeds[1].Name != "b" {
+		t.Fatalf("feeds not sorted by name: %s", resp.Feeds[1].Name)
+	}
+}
+
+func TestSubscriptionInfo(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("orders.eu")
+	s := b.NewSubscriptionWithOptions(SubscriptionOptions{Ack: true, Owner: "alice"})
+	b.SubscribePattern(s, "orders.*")
+	b.NewEvent(feed, 1)
+	b.NewEvent(feed, 2)
+	s.takeEvents(1, 0)
+
+	info := s.Info()
+	if info.Owner != "alice" || info.Queued != 1 || info.InFlight != 1 || info.Listening {
+		t.Fatalf("subscription info %+v", info)
+	}
+	if len(info.Feeds) != 1 || len(info.Patterns) != 1 {
+		t.Fatalf("feeds %v, patterns %v", info.Feeds, info.Patterns)
+	}
+}
+
+func TestExtractPage(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+		limit  int
+	}{
+		{"", 0, adminDefaultLimit},
+		{"offset=5&limit=10", 5, 10},
+		{"offset=50", 20, adminDefaultLimit},
+		{"offset=-1&limit=0", 0, adminDefaultLimit},
+		{"limit=100000", 0, adminMaxLimit},
+	}
+
+	for _, test := range tests {
+		offset, limit := extractPage(httptest.NewRequest("GET", "/?"+test.query, nil), 20)
+		if offset != test.offset || limit != test.limit {
+			t.Errorf("%s: offset %d limit %d, want %d and %d", test.query, offset, limit, test.offset, test.limit)
+		}
+	}
+}
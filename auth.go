@@ -0,0 +1,193 @@
+package lp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// Action is an operation checked by the Authorizer
+type Action string
+
+// Authorized actions
+const (
+	// ActionCreate creates a feed
+	ActionCreate Action = "create"
+	// ActionPublish publishes an event in a feed
+	ActionPublish Action = "publish"
+	// ActionSubscribe creates a subscription, or changes its feeds
+	ActionSubscribe Action = "subscribe"
+	// ActionListen receives (and acknowledges) the events of a subscription
+	ActionListen Action = "listen"
+	// ActionAdmin inspects the broker and deletes feeds
+	ActionAdmin Action = "admin"
+)
+
+// ErrUnauthenticated is returned by an Authorizer when the caller must
+// authenticate. The handlers answer 401.
+var ErrUnauthenticated = errors.New("authentication required")
+
+// ErrForbidden is returned by an Authorizer when the caller is not allowed
+// to perform the action. The handlers answer 403.
+var ErrForbidden = errors.New("forbidden")
+
+// Authorizer decides if a request can perform an action on some feeds.
+// feeds are the feed names (or patterns) involved in the action; for the
+// listen action they are the subscription feeds and patterns. Returning an
+// error denies the request.
+type Authorizer interface {
+	Authorize(r *http.Request, action Action, feeds []string) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(r *http.Request, action Action, feeds []string) error
+
+// Authorize calls f(r, action, feeds)
+func (f AuthorizerFunc) Authorize(r *http.Request, action Action, feeds []string) error {
+	return f(r, action, feeds)
+}
+
+// PrincipalFunc returns the caller identity of a request, or an empty string
+// for anonymous callers
+type PrincipalFunc func(r *http.Request) string
+
+// BasicAuth returns a PrincipalFunc authenticating the callers with HTTP
+// basic auth against a user/password map
+func BasicAuth(credentials map[string]string) PrincipalFunc {
+	return func(r *http.Request) string {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return ""
+		}
+		expected, exists := credentials[user]
+		if !exists || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+			return ""
+		}
+		return user
+	}
+}
+
+// ACLRule allows a principal to perform some actions on the feeds matching
+// a pattern. Principal "*" matches every caller, anonymous ones included.
+// Feeds is a feed name or pattern; empty matches every feed.
+type ACLRule struct {
+	Principal string
+	Feeds     string
+	Actions   []Action
+}
+
+// StaticACL is an Authorizer based on a fixed list of rules: a request is
+// allowed when every requested feed is covered by a rule of its principal
+// allowing the action. Principal identifies the caller; nil means that all
+// the callers are anonymous.
+type StaticACL struct {
+	Rules     []ACLRule
+	Principal PrincipalFunc
+}
+
+// Authorize checks the request against the rules
+func (acl *StaticACL) Authorize(r *http.Request, action Action, feeds []string) error {
+	principal := ""
+	if acl.Principal != nil {
+		principal = acl.Principal(r)
+	}
+
+	rules := make([]ACLRule, 0)
+	for _, rule := range acl.Rules {
+		if rule.allows(principal, action) {
+			rules = append(rules, rule)
+		}
+	}
+
+	allowed := len(rules) > 0
+	for _, feedName := range feeds {
+		covered := false
+		for _, rule := range rules {
+			if rule.Feeds == "" || patternCovers(rule.Feeds, feedName) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			allowed = false
+			break
+		}
+	}
+
+	if allowed {
+		return nil
+	}
+	if principal == "" {
+		return ErrUnauthenticated
+	}
+	return ErrForbidden
+}
+
+// allows checks the rule principal and actions
+func (rule ACLRule) allows(principal string, action Action) bool {
+	if rule.Principal != "*" && rule.Principal != principal {
+		return false
+	}
+	for _, a := range rule.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// patternCovers returns true if every feed matched by name (a feed name or
+// pattern) is matched by pattern too
+func patternCovers(pattern string, name string) bool {
+	patternTokens := splitFeedName(pattern)
+	nameTokens := splitFeedName(name)
+
+	for i, token := range patternTokens {
+		if token == wildcardTail {
+			return len(nameTokens) > i
+		}
+		if i >= len(nameTokens) {
+			return false
+		}
+		switch token {
+		case wildcardToken:
+			if nameTokens[i] == wildcardTail {
+				return false
+			}
+		case nameTokens[i]:
+		default:
+			return false
+		}
+	}
+	return len(patternTokens) == len(nameTokens)
+}
+
+// checkAuthorization checks a request with the broker Authorizer, if any
+func (b *Broker) checkAuthorization(r *http.Request, action Action, feeds []string) error {
+	if b.opts.Authorizer == nil {
+		return nil
+	}
+	return b.opts.Authorizer.Authorize(r, action, feeds)
+}
+
+// authorize checks a request with the broker Authorizer. If the request is
+// denied the error is sent and false is returned.
+func (b *Broker) authorize(w http.ResponseWriter, r *http.Request, action Action, feeds []string) bool {
+	err := b.checkAuthorization(r, action, feeds)
+	if err == nil {
+		return true
+	}
+	if err == ErrUnauthenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="lp"`)
+		b.sendError(w, r, 401, err.Error())
+		return false
+	}
+	b.sendError(w, r, 403, err.Error())
+	return false
+}
+
+// subscriptionFeeds returns the feeds and the patterns of a subscription,
+// checked by the listen action
+func subscriptionFeeds(s *Subscription) []string {
+	return append(s.FeedNames(), s.Patterns()...)
+}
@@ -0,0 +1,73 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPatternCovers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		covers  bool
+	}{
+		{"orders.>", "orders.eu", true},
+		{"orders.>", "orders.*", true},
+		{"orders.>", "orders.>", true},
+		{"orders.>", "orders", false},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "orders.*", true},
+		{"orders.*", "orders.>", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.eu", "orders.eu", true},
+		{"orders.eu", "orders.*", false},
+		{"*.created", "orders.created", true},
+		{"*.created", "*.created", true},
+		{"*.created", "orders.>", false},
+		{">", "orders.>", true},
+	}
+
+	for _, test := range tests {
+		if covers := patternCovers(test.pattern, test.name); covers != test.covers {
+			t.Errorf("%s %s: covers %v, want %v", test.pattern, test.name, covers, test.covers)
+		}
+	}
+}
+
+func TestStaticACL(t *testing.T) {
+	acl := &StaticACL{
+		Principal: BasicAuth(map[string]string{"alice": "secret", "bob": "secret"}),
+		Rules: []ACLRule{
+			{Principal: "*", Feeds: "public.>", Actions: []Action{ActionSubscribe, ActionListen}},
+			{Principal: "alice", Feeds: "orders.*", Actions: []Action{ActionPublish, ActionSubscribe}},
+			{Principal: "bob", Actions: []Action{ActionAdmin}},
+		},
+	}
+
+	tests := []struct {
+		user   string
+		action Action
+		feeds  []string
+		err    error
+	}{
+		{"", ActionSubscribe, []string{"public.news"}, nil},
+		{"", ActionSubscribe, []string{"orders.eu"}, ErrUnauthenticated},
+		{"alice", ActionPublish, []string{"orders.eu"}, nil},
+		{"alice", ActionSubscribe, []string{"orders.*", "public.>"}, nil},
+		{"alice", ActionSubscribe, []string{"orders.>"}, ErrForbidden},
+		{"alice", ActionPublish, []string{"public.news"}, ErrForbidden},
+		{"alice", ActionAdmin, nil, ErrForbidden},
+		{"bob", ActionAdmin, []string{"orders.eu"}, nil},
+		{"bob", ActionPublish, []string{"orders.eu"}, ErrForbidden},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, "secret")
+		}
+		if err := acl.Authorize(r, test.action, test.feeds); err != test.err {
+			t.Errorf("%s %s %v: error %v, want %v", test.user, test.action, test.feeds, err, test.err)
+		}
+	}
+}
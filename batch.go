@@ -0,0 +1,73 @@
+package lp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// batchLimits are the batching parameters of a listen request
+type batchLimits struct {
+	linger    time.Duration
+	maxEvents int
+	maxBytes  int
+}
+
+// extractBatch returns the linger (milliseconds), maxevents and maxbytes
+// parameters, falling back to the broker defaults
+func (b *Broker) extractBatch(r *http.Request) (batchLimits, error) {
+	batch := batchLimits{b.opts.Linger, b.opts.MaxEvents, b.opts.MaxBytes}
+	query := r.URL.Query()
+
+	if linger := query.Get("linger"); linger != "" {
+		ms, err := strconv.Atoi(linger)
+		if err != nil || ms < 0 {
+			return batch, errors.New("not valid linger")
+		}
+		batch.linger = time.Duration(ms) * time.Millisecond
+	}
+
+	if maxEvents := query.Get("maxevents"); maxEvents != "" {
+		var err error
+		if batch.maxEvents, err = strconv.Atoi(maxEvents); err != nil || batch.maxEvents < 0 {
+			return batch, errors.New("not valid maxevents")
+		}
+	}
+
+	if maxBytes := query.Get("maxbytes"); maxBytes != "" {
+		var err error
+		if batch.maxBytes, err = strconv.Atoi(maxBytes); err != nil || batch.maxBytes < 0 {
+			return batch, errors.New("not valid maxbytes")
+		}
+	}
+
+	return batch, nil
+}
+
+// linger waits up to linger (and not after the deadline) for more events to
+// be queued. It returns early when maxEvents events are queued or if a
+// signal other than stateReady is received.
+func (s *Subscription) linger(signal chan state, linger time.Duration, deadline time.Time, maxEvents int) state {
+	until := time.Now().Add(linger)
+	if until.After(deadline) {
+		until = deadline
+	}
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+
+	for {
+		if maxEvents > 0 && s.queued() >= maxEvents {
+			return stateReady
+		}
+
+		select {
+		case st := <-signal:
+			if st != stateReady {
+				return st
+			}
+		case <-timer.C:
+			return stateReady
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func eventSize(e *Event) int {
+	json, _ := toJSON(e.data())
+	return len(json)
+}
+
+func TestTakeEventsLimits(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	published := make([]*Event, 0)
+	for i := 0; i < 5; i++ {
+		ev, _ := b.NewEvent(feed, "payload")
+		published = append(published, ev)
+	}
+	twoEvents := eventSize(published[2]) + eventSize(published[3])
+
+	tests := []struct {
+		name      string
+		maxEvents int
+		maxBytes  int
+		want      []uint64
+	}{
+		{"max events", 2, 0, []uint64{1, 2}},
+		{"max bytes", 0, twoEvents, []uint64{3, 4}},
+		{"first event too big", 0, 1, []uint64{5}},
+	}
+
+	for _, test := range tests {
+		events, _ := s.takeEvents(test.maxEvents, test.maxBytes)
+		if seqs := eventSeqs(events); !equalSeqs(seqs, test.want) {
+			t.Errorf("%s: took %v, want %v", test.name, seqs, test.want)
+		}
+	}
+	if queued := s.queued(); queued != 0 {
+		t.Fatalf("%d events still queued", queued)
+	}
+}
+
+func TestLinger(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	signal := s.listen()
+	defer s.release(signal)
+
+	// maxEvents queued events end the wait
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.NewEvent(feed, i)
+		}
+	}()
+	<-signal
+	start := time.Now()
+	if st := s.linger(signal, time.Minute, time.Now().Add(time.Minute), 3); st != stateReady {
+		t.Fatalf("state %d, want %d", st, stateReady)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("linger did not return at maxEvents")
+	}
+
+	// The deadline comes before the linger window
+	start = time.Now()
+	s.linger(signal, time.Minute, time.Now().Add(50*time.Millisecond), 10)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 10*time.Second {
+		t.Fatalf("linger returned after %s", elapsed)
+	}
+}
+
+func TestExtractBatch(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger, MaxEvents: 100, Linger: time.Second})
+	defer b.Close()
+
+	tests := []struct {
+		query string
+		batch batchLimits
+		valid bool
+	}{
+		{"", batchLimits{time.Second, 100, 0}, true},
+		{"linger=20&maxevents=5&maxbytes=1024", batchLimits{20 * time.Millisecond, 5, 1024}, true},
+		{"linger=-1", batchLimits{}, false},
+		{"maxevents=x", batchLimits{}, false},
+		{"maxbytes=-5", batchLimits{}, false},
+	}
+
+	for _, test := range tests {
+		batch, err := b.extractBatch(httptest.NewRequest("GET", "/?"+test.query, nil))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v", test.query, err)
+			continue
+		}
+		if test.valid && batch != test.batch {
+			t.Errorf("%s: batch %+v, want %+v", test.query, batch, test.batch)
+		}
+	}
+}
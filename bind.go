@@ -0,0 +1,123 @@
+package lp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// A subscription id is enough to listen the subscription events. With
+// Options.BindSubscriptions a subscription is bound to the principal that
+// created it and it can be used only by the same principal.
+
+// PrincipalResolver can be implemented by an Authorizer to tell the broker
+// the principal of a request
+type PrincipalResolver interface {
+	ResolvePrincipal(r *http.Request) string
+}
+
+// ResolvePrincipal returns the request principal found by the ACL
+// PrincipalFunc
+func (acl *StaticACL) ResolvePrincipal(r *http.Request) string {
+	if acl.Principal == nil {
+		return ""
+	}
+	return acl.Principal(r)
+}
+
+// CookiePrincipal returns a PrincipalFunc verifying a cookie holding a
+// token created by SignToken with the same key. Unsigned or tampered cookies
+// have no principal.
+func CookiePrincipal(name string, key []byte) PrincipalFunc {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return verifyToken(key, cookie.Value)
+	}
+}
+
+// TokenPrincipal returns a PrincipalFunc verifying the tokens created by
+// SignToken with the same key. The token is read from the Authorization
+// header (Bearer scheme).
+func TokenPrincipal(key []byte) PrincipalFunc {
+	return func(r *http.Request) string {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return ""
+		}
+		return verifyToken(key, strings.TrimPrefix(auth, "Bearer "))
+	}
+}
+
+// QueryTokenPrincipal is TokenPrincipal, also reading the token from the
+// token query parameter, for the clients that can not set headers (browser
+// EventSource and WebSocket). URLs end up in access logs, proxy logs and
+// browser history, so the tokens sent this way should be short lived.
+func QueryTokenPrincipal(key []byte) PrincipalFunc {
+	header := TokenPrincipal(key)
+	return func(r *http.Request) string {
+		if principal := header(r); principal != "" {
+			return principal
+		}
+		return verifyToken(key, r.URL.Query().Get("token"))
+	}
+}
+
+// SignToken returns a token identifying a principal, signed with HMAC-SHA256
+func SignToken(key []byte, principal string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(principal))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(key, encoded))
+}
+
+// verifyToken returns the principal of a valid token, or an empty string
+func verifyToken(key []byte, token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ""
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, tokenSignature(key, parts[0])) {
+		return ""
+	}
+	principal, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ""
+	}
+	return string(principal)
+}
+
+func tokenSignature(key []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// principal returns the principal of a request: Options.Principal, if set,
+// otherwise the Authorizer one, if it is a PrincipalResolver
+func (b *Broker) principal(r *http.Request) string {
+	if b.opts.Principal != nil {
+		return b.opts.Principal(r)
+	}
+	if resolver, ok := b.opts.Authorizer.(PrincipalResolver); ok {
+		return resolver.ResolvePrincipal(r)
+	}
+	return ""
+}
+
+// checkOwner checks that a request comes from the subscription owner, when
+// the subscriptions are bound. If it does not the error is sent and false
+// is returned.
+func (b *Broker) checkOwner(w http.ResponseWriter, r *http.Request, s *Subscription) bool {
+	if !b.opts.BindSubscriptions {
+		return true
+	}
+	if b.principal(r) != s.opts.Owner {
+		b.sendError(w, r, 403, "subscription belongs to another client")
+		return false
+	}
+	return true
+}
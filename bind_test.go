@@ -0,0 +1,94 @@
+package lp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenPrincipal(t *testing.T) {
+	key := []byte("secret")
+	token := SignToken(key, "alice")
+	forged := SignToken([]byte("other"), "alice")
+
+	tests := []struct {
+		name      string
+		header    string
+		query     string
+		principal string
+		fromQuery string
+	}{
+		{"bearer", "Bearer " + token, "", "alice", "alice"},
+		{"forged", "Bearer " + forged, "", "", ""},
+		{"tampered", "Bearer x" + token, "", "", ""},
+		{"query", "", token, "", "alice"},
+		{"no token", "", "", "", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/listen?token="+test.query, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		if principal := TokenPrincipal(key)(r); principal != test.principal {
+			t.Errorf("%s: principal %q, want %q", test.name, principal, test.principal)
+		}
+		if principal := QueryTokenPrincipal(key)(r); principal != test.fromQuery {
+			t.Errorf("%s: query principal %q, want %q", test.name, principal, test.fromQuery)
+		}
+	}
+}
+
+func TestCookiePrincipal(t *testing.T) {
+	key := []byte("secret")
+	principal := CookiePrincipal("session", key)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: SignToken(key, "bob")})
+	if got := principal(r); got != "bob" {
+		t.Fatalf("principal %q, want bob", got)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "bob"})
+	if got := principal(r); got != "" {
+		t.Fatalf("unsigned cookie principal %q", got)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	key := []byte("secret")
+	b := NewBroker(Options{Logger: DiscardLogger, BindSubscriptions: true, Principal: TokenPrincipal(key)})
+	defer b.Close()
+	s := b.NewSubscriptionWithOptions(SubscriptionOptions{Owner: "alice"})
+
+	tests := []struct {
+		principal string
+		allowed   bool
+	}{
+		{"alice", true},
+		{"bob", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/listen", nil)
+		if test.principal != "" {
+			r.Header.Set("Authorization", "Bearer "+SignToken(key, test.principal))
+		}
+		w := httptest.NewRecorder()
+		if allowed := b.checkOwner(w, r, s); allowed != test.allowed {
+			t.Errorf("%q: allowed %v, want %v", test.principal, allowed, test.allowed)
+		}
+		if !test.allowed && w.Code != 403 {
+			t.Errorf("%q: status %d, want 403", test.principal, w.Code)
+		}
+	}
+
+	// Without binding any client can use a subscription
+	unbound := NewBroker(Options{Logger: DiscardLogger})
+	defer unbound.Close()
+	if !unbound.checkOwner(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), s) {
+		t.Fatal("subscription bound without BindSubscriptions")
+	}
+}
@@ -0,0 +1,216 @@
+package lp
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Options are the broker configuration parameters
+type Options struct {
+	// EventParser parses the body of the events received from the REST end
+	// point. It can be registered later with Broker.RegisterEventParser.
+	EventParser EventParserFunction
+	// Timeout is the default listen timeout, in seconds (default 30)
+	Timeout int
+	// Store is where the events are saved (default in memory)
+	Store EventStore
+	// Retention is the default retention policy, used by the feeds that do
+	// not set their own
+	Retention Retention
+	// CompactInterval is how often the retention policies are enforced
+	// (default 1 minute)
+	CompactInterval time.Duration
+	// SubscriptionTTL is how long a subscription can stay without listeners
+	// before it expires. Zero means subscriptions never expire.
+	SubscriptionTTL time.Duration
+	// OnExpire, if set, is registered as Broker.OnExpire hook
+	OnExpire func(s *Subscription)
+	// MaxQueue is the default maximum number of queued events for a
+	// subscription. Zero means no limit.
+	MaxQueue int
+	// Overflow is the default policy applied when a subscription queue is
+	// full (default OverflowDropOldest)
+	Overflow OverflowPolicy
+	// Heartbeat is the interval between the heartbeats sent on the open
+	// streams (default 15 seconds). It is also the write timeout of the
+	// websocket frames.
+	Heartbeat time.Duration
+	// Linger is how long a listen waits for more events once the first one
+	// is ready, so they are sent in a single response
+	Linger time.Duration
+	// MaxEvents is the maximum number of events in a listen response. Zero
+	// means no limit.
+	MaxEvents int
+	// MaxBytes is the maximum size of the events in a listen response. Zero
+	// means no limit.
+	MaxBytes int
+	// AckTimeout is how long a delivered event waits to be acknowledged by
+	// the subscriptions in ack mode, before being delivered again (default
+	// 30 seconds)
+	AckTimeout time.Duration
+	// Authorizer checks every request served by the handlers. Nil allows all
+	// the requests.
+	Authorizer Authorizer
+	// Principal identifies the client of a request. Nil falls back to the
+	// Authorizer, if it is a PrincipalResolver.
+	Principal PrincipalFunc
+	// BindSubscriptions binds each subscription to the principal that
+	// created it: the requests of other principals are refused
+	BindSubscriptions bool
+	// IDGenerator generates the ids of the feeds, subscriptions and events
+	// (default ULIDGenerator)
+	IDGenerator IDGenerator
+	// PublishLimit limits the published events (counted by feed, if Key is
+	// not set). It can be overridden by FeedOptions.PublishLimit.
+	PublishLimit RateLimit
+	// ListenLimit limits the listen requests (counted by subscription, if
+	// Key is not set)
+	ListenLimit RateLimit
+	// Logger receives the broker logs (default slog.Default()).
+	// DiscardLogger silences the broker.
+	Logger Logger
+	// AllowedOrigins are the origins (eg: https://example.com) allowed to
+	// open a websocket; "*" allows any origin. Empty allows only the same
+	// host. Requests without Origin (not from a browser) are always allowed.
+	AllowedOrigins []string
+	// ShutdownRedirect is the URL of another node, sent to the clients by
+	// Shutdown so they reconnect there
+	ShutdownRedirect string
+}
+
+// Broker owns a feed registry, a subscription table, an event store and an
+// event parser. Brokers are isolated from each other, so more than one can
+// run in the same process.
+type Broker struct {
+	l              sync.Mutex
+	opts           Options
+	feeds          map[uuid]*Feed
+	feedNameToUUID map[string]uuid
+	subscriptions  map[uuid]*Subscription
+	restored       map[uuid]SubscriptionState
+	restoredAt     time.Time
+	store          EventStore
+	parserFunction EventParserFunction
+	ids            IDGenerator
+	limiter        *rateLimiter
+	metrics        *metrics
+	logger         Logger
+	hooks          *hooks
+	handlers       handlerGroup
+	shuttingDown   bool
+	redelivering   bool
+	publishLock    sync.Mutex
+	seq            uint64
+	done           chan struct{}
+	wg             sync.WaitGroup
+	closeOnce      sync.Once
+}
+
+// NewBroker creates a new broker
+func NewBroker(opts Options) *Broker {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = time.Minute
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = 15 * time.Second
+	}
+	if opts.AckTimeout == 0 {
+		opts.AckTimeout = 30 * time.Second
+	}
+
+	b := new(Broker)
+	b.opts = opts
+	b.logger = opts.Logger
+	if b.logger == nil {
+		b.logger = defaultLogger()
+	}
+	b.feeds = make(map[uuid]*Feed)
+	b.feedNameToUUID = make(map[string]uuid)
+	b.subscriptions = make(map[uuid]*Subscription)
+	b.store = opts.Store
+	if b.store == nil {
+		b.store = NewMemoryEventStore()
+	}
+	if lastID, err := b.store.LastID(); err == nil {
+		b.seq = lastID
+	} else {
+		b.logger.Error("can not read the last event id", "error", err)
+	}
+	b.loadSubscriptions()
+	b.hooks = new(hooks)
+	if opts.OnExpire != nil {
+		b.OnExpire(opts.OnExpire)
+	}
+	b.limiter = newRateLimiter()
+	b.metrics = newMetrics()
+	b.ids = opts.IDGenerator
+	if b.ids == nil {
+		b.ids = NewULIDGenerator()
+	}
+	b.parserFunction = func(bodyString string) (interface{}, error) {
+		return nil, errors.New("Parser function not registered")
+	}
+	if opts.EventParser != nil {
+		b.parserFunction = opts.EventParser
+	}
+	b.done = make(chan struct{})
+
+	b.wg.Add(1)
+	go b.compactor()
+
+	if opts.SubscriptionTTL > 0 {
+		b.wg.Add(1)
+		go b.reaper()
+	}
+
+	return b
+}
+
+// RegisterEventParser sets the event parse logic function
+func (b *Broker) RegisterEventParser(f EventParserFunction) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	b.parserFunction = f
+}
+
+// Close stops the broker background tasks and closes its event store
+func (b *Broker) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		// The redeliverer is started holding the lock
+		b.l.Lock()
+		close(b.done)
+		b.l.Unlock()
+		b.wg.Wait()
+		err = b.store.Close()
+	})
+	return err
+}
+
+// Handler returns an http.Handler serving the broker routes. Shutdown waits
+// for the requests it is serving.
+func (b *Broker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/newfeed", b.CreateFeed)
+	mux.HandleFunc("/deletefeed", b.DeleteFeedHandler)
+	mux.HandleFunc("/newevent", b.NotifyEvent)
+	mux.HandleFunc("/subscribe", b.SubscribeHandler)
+	mux.HandleFunc("/listen", b.ListenHandler)
+	mux.HandleFunc("/sse", b.SSEHandler)
+	mux.HandleFunc("/ws", b.WebSocketHandler)
+	mux.HandleFunc("/ack", b.AckHandler)
+	mux.HandleFunc("/nack", b.NackHandler)
+	mux.HandleFunc("/subscription/add", b.AddFeedsHandler)
+	mux.HandleFunc("/subscription/remove", b.RemoveFeedsHandler)
+	mux.HandleFunc("/subscription/close", b.CloseSubscriptionHandler)
+	mux.HandleFunc("/admin/feeds", b.AdminFeedsHandler)
+	mux.HandleFunc("/admin/subscriptions", b.AdminSubscriptionsHandler)
+	mux.HandleFunc("/metrics", b.MetricsHandler)
+	return b.handlers.track(mux)
+}
@@ -0,0 +1,78 @@
+package lp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBrokersAreIsolated(t *testing.T) {
+	first := NewBroker(Options{Logger: DiscardLogger})
+	defer first.Close()
+	second := NewBroker(Options{Logger: DiscardLogger})
+	defer second.Close()
+
+	if _, err := first.NewFeed("f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.GetFeedFromName("f"); err == nil {
+		t.Fatal("feed of a broker found in another one")
+	}
+	if _, err := second.NewFeed("f"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := first.NewSubscription()
+	if _, err := second.GetSubscription(s.id); err == nil {
+		t.Fatal("subscription of a broker found in another one")
+	}
+}
+
+func TestPublishAndListen(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	b.RegisterEventParser(func(body string) (interface{}, error) { return body, nil })
+	handler := b.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/newfeed?feed=f", nil))
+	if w.Code != 200 {
+		t.Fatalf("newfeed: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/subscribe?feed=f", nil))
+	var subscription struct{ SubscriptionID string }
+	json.Unmarshal(w.Body.Bytes(), &subscription)
+	if subscription.SubscriptionID == "" {
+		t.Fatalf("subscribe: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/newevent?feed=f", strings.NewReader("hello")))
+	if w.Code != 200 {
+		t.Fatalf("newevent: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/listen?subscriptionID="+subscription.SubscriptionID, nil))
+	var resp struct{ Events []EventData }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Events) != 1 || resp.Events[0].Payload != "hello" || resp.Events[0].ID != 1 {
+		t.Fatalf("listen: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDefaultBroker(t *testing.T) {
+	if DefaultBroker() != DefaultBroker() {
+		t.Fatal("default broker created twice")
+	}
+	feed, err := NewFeed("default-broker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := DefaultBroker().GetFeedFromName("default-broker-test"); found != feed {
+		t.Fatal("package level functions not using the default broker")
+	}
+}
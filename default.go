@@ -0,0 +1,84 @@
+package lp
+
+import (
+	"net/http"
+	"sync"
+)
+
+// defaultBroker is the broker used by the package level functions. It is
+// created on first use, so importing the package does not start its
+// goroutines.
+var (
+	defaultBroker     *Broker
+	defaultBrokerOnce sync.Once
+)
+
+// DefaultBroker returns the broker used by the package level functions
+func DefaultBroker() *Broker {
+	defaultBrokerOnce.Do(func() {
+		defaultBroker = NewBroker(Options{})
+	})
+	return defaultBroker
+}
+
+// RegisterEventParser sets the event parse logic function of the default
+// broker
+func RegisterEventParser(f EventParserFunction) {
+	DefaultBroker().RegisterEventParser(f)
+}
+
+// NewFeed creates a new feed in the default broker
+func NewFeed(feedName string) (*Feed, error) {
+	return DefaultBroker().NewFeed(feedName)
+}
+
+// GetFeed returns a feed of the default broker, if exists
+func GetFeed(id uuid) (*Feed, error) {
+	return DefaultBroker().GetFeed(id)
+}
+
+// GetFeedFromName returns a feed of the default broker from a feed name, if
+// exists
+func GetFeedFromName(feedName string) (*Feed, error) {
+	return DefaultBroker().GetFeedFromName(feedName)
+}
+
+// NewSubscription creates a new subscription in the default broker
+func NewSubscription() *Subscription {
+	return DefaultBroker().NewSubscription()
+}
+
+// GetSubscription returns a subscription of the default broker, if exists
+func GetSubscription(id uuid) (*Subscription, error) {
+	return DefaultBroker().GetSubscription(id)
+}
+
+// NewEvent generates a new event in the default broker
+func NewEvent(feed *Feed, payload interface{}) (*Event, error) {
+	return DefaultBroker().NewEvent(feed, payload)
+}
+
+// CreateFeed is the default broker CreateFeed handler
+func CreateFeed(w http.ResponseWriter, r *http.Request) {
+	DefaultBroker().CreateFeed(w, r)
+}
+
+// SubscribeHandler is the default broker SubscribeHandler
+func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultBroker().SubscribeHandler(w, r)
+}
+
+// ListenHandler is the default broker ListenHandler
+func ListenHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultBroker().ListenHandler(w, r)
+}
+
+// NotifyEvent is the default broker NotifyEvent handler
+func NotifyEvent(w http.ResponseWriter, r *http.Request) {
+	DefaultBroker().NotifyEvent(w, r)
+}
+
+// LogRequest logs each request with the default broker Logger
+func LogRequest(next http.Handler) http.Handler {
+	return DefaultBroker().LogRequest(next)
+}
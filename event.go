@@ -3,81 +3,127 @@ package lp
 import (
 	"encoding/json"
 	"errors"
-	"log"
-	"sync"
 	"time"
 )
 
 // Event is the exported datamodel for an emitted event
 type Event struct {
 	id      uuid
-	feed    *Feed
+	seq     uint64
+	feed    string
 	ts      time.Time
 	payload interface{}
+	size    int
+	control string
 }
 
-type eventList struct {
-	l    sync.Mutex
-	list []*Event
-}
-
-var events *eventList
+// ControlFeedClosed is the control message of the event sent to the
+// subscribers of a deleted feed
+const ControlFeedClosed = "feed closed"
 
 // EventParserFunction is the signature of the function must be provided in
 // order to parse an incoming JSON to an internal Event.paload
 type EventParserFunction func(JSON string) (interface{}, error)
 
-var parserFunction EventParserFunction
+// parseEvent parses an incoming event body with the registered parser
+func (b *Broker) parseEvent(body string) (interface{}, error) {
+	b.l.Lock()
+	parserFunction := b.parserFunction
+	b.l.Unlock()
 
-func init() {
-	events = new(eventList)
-	events.list = make([]*Event, 0)
-	parserFunction = func(bodyString string) (interface{}, error) {
-		return nil, errors.New("Parser function not registered")
-	}
-}
-
-// RegisterEventParser sets the event parse logic function
-func RegisterEventParser(f EventParserFunction) {
-	parserFunction = f
+	return parserFunction(body)
 }
 
 // NewEvent generate a new event and prepare the internal data model
-func NewEvent(feed *Feed, payload interface{}) (*Event, error) {
+func (b *Broker) NewEvent(feed *Feed, payload interface{}) (*Event, error) {
 	ev := new(Event)
 
-	// Check if they are registered listeners
-	if len(feed.subscriptions) == 0 {
+	if feed.isDeleted() {
+		return ev, errors.New("feed " + feed.name + " has been deleted")
+	}
+
+	// Check if they are registered listeners. Feeds with history keep the
+	// events anyway.
+	subscriptions := feed.subscribers()
+	if len(subscriptions) == 0 && !feed.opts.History {
 		return ev, errors.New("no subscribers, this event will be lost")
 	}
 
 	// Prepare the event
-	ev.id = newUUID()
-	ev.feed = feed
-	ev.ts = time.Now().UTC()
+	ev.id = b.newID()
+	ev.feed = feed.name
 	ev.payload = payload
 
-	// Append the event to the global list
-	events.Append(ev)
+	// The hooks can reject or change the event
+	if err := b.hooks.publishing(ev); err != nil {
+		return ev, err
+	}
+	if encoded, err := json.Marshal(ev.payload); err == nil {
+		ev.size = len(encoded)
+	}
+
+	// Events are numbered, timestamped, stored and queued in the same order,
+	// so the sequence can be used by the clients as a cursor and the store
+	// time ranges agree with it
+	b.publishLock.Lock()
+	defer b.publishLock.Unlock()
 
-	log.Printf("New event received, broadcasting to %d clients.\n", len(feed.subscriptions))
+	b.seq++
+	ev.seq = b.seq
+	ev.ts = time.Now().UTC()
 
-	// Notify listener
-	for _, s := range feed.subscriptions {
-		// Event subscription can happen in parallel, because it is thread safe
-		go s.NotifyEvent(ev)
+	// Append the event to the broker store
+	if err := b.store.Append(ev); err != nil {
+		return ev, err
+	}
+
+	b.metrics.eventPublished(feed.name)
+
+	feed.l.Lock()
+	feed.published++
+	feed.lastPublish = ev.ts
+	feed.l.Unlock()
+
+	b.logger.Debug("event published", "feed", feed.name, "eventID", ev.seq, "subscribers", len(subscriptions))
+
+	// Notify listener, if the event matches its filter. NotifyEvent does
+	// not block.
+	view := &filterEvent{ev: ev}
+	for _, s := range subscriptions {
+		if !s.opts.Filter.match(view) {
+			continue
+		}
+		s.NotifyEvent(ev)
 	}
 
 	return ev, nil
 }
 
-// Append adds an event to the global event list
-// It is thread safe
-func (el *eventList) Append(ev *Event) {
-	el.l.Lock()
-	defer el.l.Unlock()
+// ID returns the event id. Event ids are increasing in publishing order and
+// can be used as cursor to resume a subscription.
+func (ev *Event) ID() uint64 {
+	return ev.seq
+}
+
+// Feed returns the name of the feed of the event
+func (ev *Event) Feed() string {
+	return ev.feed
+}
+
+// Timestamp returns the time the event has been published
+func (ev *Event) Timestamp() time.Time {
+	return ev.ts
+}
 
-	el.list = append(el.list, ev)
+// Payload returns the event payload
+func (ev *Event) Payload() interface{} {
+	return ev.payload
+}
+
+// SetPayload replaces the event payload. It can be used by the OnPublish
+// hooks, before the event is stored and sent.
+func (ev *Event) SetPayload(payload interface{}) {
+	ev.payload = payload
 }
 
 // ToJSON returns a json encoded reppresentation of an Event object
@@ -87,7 +133,7 @@ func (ev Event) ToJSON() (string, error) {
 		Stamp   time.Time
 		Payload interface{}
 	}{
-		ev.feed.name,
+		ev.feed,
 		ev.ts,
 		ev.payload,
 	}
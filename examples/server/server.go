@@ -10,27 +10,26 @@ import (
 
 func main() {
 
-	// Registers a event parse function. This function is application domain
-	// specific and must be provided. This function receive the body of each
-	// new event request (as string) and returns a interface{}. The returned
-	// value will be used as payload in the internal event structure.
+	// Creates a new broker, registering an event parse function. This
+	// function is application domain specific and must be provided. This
+	// function receive the body of each new event request (as string) and
+	// returns a interface{}. The returned value will be used as payload in the
+	// internal event structure.
 	// This function is required only if you want to receive new events from
 	// the REST end point
-	lp.RegisterEventParser(eventParser)
+	broker := lp.NewBroker(lp.Options{
+		EventParser: eventParser,
+	})
 
 	// Create a new feed
-	feed1, _ := lp.NewFeed("feed1")
+	feed1, _ := broker.NewFeed("feed1")
 
 	// Send events generated from the server
-	go simulateServerEvents(feed1)
-
-	http.HandleFunc("/newfeed", lp.CreateFeed)
-	http.HandleFunc("/newevent", lp.NotifyEvent)
-	http.HandleFunc("/subscribe", lp.SubscribeHandler)
-	http.HandleFunc("/listen", lp.ListenHandler)
+	go simulateServerEvents(broker, feed1)
 
+	// The broker handler serves /newfeed, /newevent, /subscribe and /listen
 	log.Println("Listening on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", broker.Handler()))
 }
 
 var eventParser = func(bodyString string) (interface{}, error) {
@@ -51,12 +50,12 @@ var eventParser = func(bodyString string) (interface{}, error) {
 }
 
 // This function simulates new events incoming
-func simulateServerEvents(feed *lp.Feed) {
+func simulateServerEvents(broker *lp.Broker, feed *lp.Feed) {
 	for {
 		time.Sleep(10 * time.Second)
 		type payload struct {
 			Data string
 		}
-		lp.NewEvent(feed, payload{"This is some data generated by the server. It will not be parsed by the eventParse function"})
+		broker.NewEvent(feed, payload{"This is some data generated by the server. It will not be parsed by the eventParse function"})
 	}
 }
@@ -4,71 +4,153 @@ import (
 	"errors"
 	"log"
 	"sync"
+	"time"
 )
 
-var feedLock sync.Mutex
-var feeds map[uuid]*Feed
-var feedNameToUUID map[string]uuid
-
-func init() {
-	feeds = make(map[uuid]*Feed)
-	feedNameToUUID = make(map[string]uuid)
-}
-
 // Feed is the object that reppresent a feed
 type Feed struct {
 	l             sync.Mutex
 	name          string
 	id            uuid
 	subscriptions map[uuid]*Subscription
+	opts          FeedOptions
+	deleted       bool
+	published     int64
+	lastPublish   time.Time
+}
+
+// FeedOptions are the per feed configuration parameters
+type FeedOptions struct {
+	// Retention is the feed retention policy. Zero fields fall back to the
+	// broker default retention.
+	Retention Retention
+	// MaxQueue is the default maximum number of queued events for the
+	// subscriptions of the feed
+	MaxQueue int
+	// Overflow is the default policy applied when a subscription queue is
+	// full
+	Overflow OverflowPolicy
+	// History keeps the events published without subscribers, so late
+	// subscribers can fetch them. The history is bounded by the retention.
+	History bool
+	// PublishLimit limits the events published in the feed. Zero fields fall
+	// back to the broker default limit.
+	PublishLimit RateLimit
 }
 
 // NewFeed tries to create a new feed and returns it
-func NewFeed(feedName string) (*Feed, error) {
-	// Locl to be sure feedName is uniq
-	feedLock.Lock()
-	defer feedLock.Unlock()
+func (b *Broker) NewFeed(feedName string) (*Feed, error) {
+	return b.NewFeedWithOptions(feedName, FeedOptions{})
+}
 
+// NewFeedWithOptions tries to create a new feed with specific options and
+// returns it. The subscriptions with a matching pattern are subscribed to the
+// new feed.
+func (b *Broker) NewFeedWithOptions(feedName string, opts FeedOptions) (*Feed, error) {
 	f := new(Feed)
-	if _, exists := feedNameToUUID[feedName]; exists {
+	if len(splitFeedName(feedName)) == 0 {
+		return f, errors.New("not valid feed name")
+	}
+	if IsFeedPattern(feedName) {
+		return f, errors.New("feed name " + feedName + " can not contain wildcards")
+	}
+
+	// Lock to be sure feedName is uniq
+	b.l.Lock()
+	if _, exists := b.feedNameToUUID[feedName]; exists {
+		b.l.Unlock()
 		return f, errors.New("feed " + feedName + " exists")
 	}
-	id := newUUID()
-	feedNameToUUID[feedName] = id
+	id := b.newID()
+	b.feedNameToUUID[feedName] = id
 	f.name = feedName
 	f.id = id
+	f.opts = opts
 	f.subscriptions = make(map[uuid]*Subscription)
-	feeds[f.id] = f
+	b.feeds[f.id] = f
+	subscriptions := b.matchingSubscriptions(feedName)
+	b.l.Unlock()
+
+	b.hooks.feedCreated(f)
+
+	for _, s := range subscriptions {
+		s.subscribe(f, false)
+	}
 	return f, nil
 }
 
 // GetFeed returns a feed object ptr, if exists
-func GetFeed(id uuid) (*Feed, error) {
+func (b *Broker) GetFeed(id uuid) (*Feed, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
 	var f *Feed
 	var exists bool
-	if f, exists = feeds[id]; exists == false {
+	if f, exists = b.feeds[id]; exists == false {
 		return f, errors.New("feed " + string(id) + " does not exists")
 	}
 	return f, nil
 }
 
 // GetFeedFromName returns a feed ptr from a feed name, if exists
-func GetFeedFromName(feedName string) (*Feed, error) {
+func (b *Broker) GetFeedFromName(feedName string) (*Feed, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
 	var id uuid
 	var exists bool
-	if id, exists = feedNameToUUID[feedName]; exists == false {
+	if id, exists = b.feedNameToUUID[feedName]; exists == false {
 		return new(Feed), errors.New("feed " + feedName + " does not exists")
 	}
 
-	f := feeds[id]
+	f := b.feeds[id]
 	return f, nil
 }
 
+// DeleteFeed removes a feed, so its name can be reused. The subscriptions
+// are detached from the feed and their listeners receive a "feed closed"
+// control event. The feed events are removed from the store.
+func (b *Broker) DeleteFeed(feedName string) error {
+	b.l.Lock()
+	id, exists := b.feedNameToUUID[feedName]
+	if exists == false {
+		b.l.Unlock()
+		return errors.New("feed " + feedName + " does not exists")
+	}
+	f := b.feeds[id]
+	delete(b.feedNameToUUID, feedName)
+	delete(b.feeds, id)
+	b.l.Unlock()
+
+	// New events and subscriptions are refused from now on
+	f.l.Lock()
+	f.deleted = true
+	f.l.Unlock()
+
+	ev := &Event{
+		id:      b.newID(),
+		feed:    f.name,
+		ts:      time.Now().UTC(),
+		control: ControlFeedClosed,
+	}
+	for _, s := range f.subscribers() {
+		s.Unsubscribe(f)
+		s.NotifyEvent(ev)
+	}
+
+	b.metrics.removeFeed(f.name)
+
+	return b.store.Truncate(f.name, ev.ts.Add(time.Nanosecond))
+}
+
 // addSubscription add a connection to a specific feed
 func (f *Feed) addSubscription(c *Subscription) error {
-	c.l.Lock()
-	defer c.l.Unlock()
+	f.l.Lock()
+	defer f.l.Unlock()
 
+	if f.deleted {
+		return errors.New("feed " + f.name + " has been deleted")
+	}
 	if _, exists := f.subscriptions[c.id]; exists {
 		return errors.New("connection " + string(c.id) + " already subscribed feed " + f.name)
 	}
@@ -78,13 +160,33 @@ func (f *Feed) addSubscription(c *Subscription) error {
 
 // removeSubscription remove a connection to a specific feed
 func (f *Feed) removeSubscription(c *Subscription) error {
-	c.l.Lock()
-	defer c.l.Unlock()
+	f.l.Lock()
+	defer f.l.Unlock()
 
 	delete(f.subscriptions, c.id)
 	return nil
 }
 
+// isDeleted returns true if the feed has been deleted
+func (f *Feed) isDeleted() bool {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.deleted
+}
+
+// subscribers returns a copy of the feed subscription list
+func (f *Feed) subscribers() []*Subscription {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	list := make([]*Subscription, 0, len(f.subscriptions))
+	for _, s := range f.subscriptions {
+		list = append(list, s)
+	}
+	return list
+}
+
 func (f *Feed) String() string {
 	return f.name + "( F:" + string(f.id) + ")"
 }
@@ -92,7 +194,7 @@ func (f *Feed) String() string {
 // Log logs connection in STDOUT
 func (f *Feed) Log() {
 	log.Printf("%s (%v)\n", f.name, f.id)
-	for _, c := range f.subscriptions {
+	for _, c := range f.subscribers() {
 		log.Printf("|-- %s\n", c)
 	}
 	log.Print("\n")
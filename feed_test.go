@@ -0,0 +1,72 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeleteFeed(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	other, _ := b.NewFeed("other")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	s.Subscribe(other)
+	b.NewEvent(feed, 1)
+
+	if err := b.DeleteFeed("f"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The subscribers are detached and receive a control event
+	events := s.GetEvents()
+	if len(events) != 2 || events[1].control != ControlFeedClosed || events[1].feed != "f" {
+		t.Fatalf("queued %+v, want the event and the feed closed control event", events)
+	}
+	if names := s.FeedNames(); len(names) != 1 || names[0] != "other" {
+		t.Fatalf("subscribed %v, want [other]", names)
+	}
+
+	// The deleted feed refuses events, its stored events are removed and
+	// its name can be reused
+	if _, err := b.NewEvent(feed, 2); err == nil {
+		t.Fatal("event published on a deleted feed")
+	}
+	if stored, _ := b.store.Read("f", time.Time{}, time.Time{}); len(stored) != 0 {
+		t.Fatalf("%d events of the deleted feed still stored", len(stored))
+	}
+	if _, err := b.NewFeed("f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteFeed("missing"); err == nil {
+		t.Fatal("deleted a missing feed")
+	}
+}
+
+func TestDeleteFeedHandler(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	b.NewFeed("f")
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"", 400},
+		{"feed=a&feed=b", 400},
+		{"feed=missing", 404},
+		{"feed=f", 200},
+		{"feed=f", 404},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		b.DeleteFeedHandler(w, httptest.NewRequest("GET", "/deletefeed?"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.query, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package lp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStoreSegmentSize is the size after which a new segment file is opened
+const fileStoreSegmentSize = 16 << 20
+
+// fileStoreSubscriptions is the file of the saved subscriptions
+const fileStoreSubscriptions = "subscriptions.json"
+
+const (
+	recordAppend   = "append"
+	recordTruncate = "truncate"
+)
+
+// fileRecord is a single entry of the log. The Seq of a truncate record is
+// the last event id, so it is recovered even when the segments holding the
+// events have been deleted.
+type fileRecord struct {
+	Op      string
+	ID      string          `json:",omitempty"`
+	Seq     uint64          `json:",omitempty"`
+	Feed    string          `json:",omitempty"`
+	TS      time.Time       `json:",omitempty"`
+	Payload json.RawMessage `json:",omitempty"`
+	Before  time.Time       `json:",omitempty"`
+}
+
+// fileSegment is a log file
+type fileSegment struct {
+	seq  uint64
+	path string
+	live int
+}
+
+// storedEvent is an event and the segment storing it
+type storedEvent struct {
+	ev      *Event
+	segment *fileSegment
+}
+
+// FileEventStore is an EventStore backed by an append-only log split in
+// segment files. Each record is synced on disk before Append returns and the
+// log is replayed when the store is opened, so events survive a crash.
+// Truncations are written in the log too; segments are deleted once they do
+// not contain any live event.
+// Payloads of recovered events are the raw JSON (json.RawMessage) written
+// when the event was appended.
+type FileEventStore struct {
+	l        sync.Mutex
+	dir      string
+	segments []*fileSegment
+	file     *os.File
+	size     int64
+	feeds    map[string][]storedEvent
+	lastID   uint64
+}
+
+// NewFileEventStore opens (or creates) a file store in the dir directory,
+// replaying the existing segments
+func NewFileEventStore(dir string) (*FileEventStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fs := new(FileEventStore)
+	fs.dir = dir
+	fs.feeds = make(map[string][]storedEvent)
+
+	if err := fs.recover(); err != nil {
+		return nil, err
+	}
+
+	if len(fs.segments) == 0 {
+		if err := fs.openSegment(1); err != nil {
+			return nil, err
+		}
+		return fs, nil
+	}
+
+	// Continue writing on the last segment
+	last := fs.segments[len(fs.segments)-1]
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fs.file = f
+	fs.size = info.Size()
+	return fs, nil
+}
+
+// Append writes the event in the log
+func (fs *FileEventStore) Append(ev *Event) error {
+	payload, err := json.Marshal(ev.payload)
+	if err != nil {
+		return err
+	}
+
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	if fs.file == nil {
+		return errors.New("store is closed")
+	}
+
+	rec := fileRecord{
+		Op:      recordAppend,
+		ID:      string(ev.id),
+		Seq:     ev.seq,
+		Feed:    ev.feed,
+		TS:      ev.ts,
+		Payload: payload,
+	}
+	if err := fs.write(rec); err != nil {
+		return err
+	}
+
+	segment := fs.segments[len(fs.segments)-1]
+	segment.live++
+	fs.feeds[ev.feed] = append(fs.feeds[ev.feed], storedEvent{ev, segment})
+	if ev.seq > fs.lastID {
+		fs.lastID = ev.seq
+	}
+	return nil
+}
+
+// Read returns the events of a feed in a time range
+func (fs *FileEventStore) Read(feed string, from time.Time, to time.Time) ([]*Event, error) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	list := make([]*Event, 0, len(fs.feeds[feed]))
+	for _, se := range fs.feeds[feed] {
+		list = append(list, se.ev)
+	}
+	return filterEvents(list, from, to), nil
+}
+
+// Truncate removes the events of a feed older than before
+func (fs *FileEventStore) Truncate(feed string, before time.Time) error {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	if fs.file == nil {
+		return errors.New("store is closed")
+	}
+
+	list := fs.feeds[feed]
+	i := 0
+	for i < len(list) && list[i].ev.ts.Before(before) {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+
+	if err := fs.write(fileRecord{Op: recordTruncate, Seq: fs.lastID, Feed: feed, Before: before}); err != nil {
+		return err
+	}
+	fs.truncate(feed, before)
+
+	return fs.removeDeadSegments()
+}
+
+// LastID returns the highest event id ever appended
+func (fs *FileEventStore) LastID() (uint64, error) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	return fs.lastID, nil
+}
+
+// Feeds returns the names of the feeds with events
+func (fs *FileEventStore) Feeds() ([]string, error) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	names := make([]string, 0, len(fs.feeds))
+	for name := range fs.feeds {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SaveSubscriptions writes the subscriptions in the store directory. The
+// file is replaced atomically, so a crash leaves the previous one.
+func (fs *FileEventStore) SaveSubscriptions(states []SubscriptionState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	path := filepath.Join(fs.dir, fileStoreSubscriptions)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return fs.syncDir()
+}
+
+// LoadSubscriptions reads the saved subscriptions, if any
+func (fs *FileEventStore) LoadSubscriptions() ([]SubscriptionState, error) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fs.dir, fileStoreSubscriptions))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []SubscriptionState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Close closes the current segment file
+func (fs *FileEventStore) Close() error {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+// truncate removes from memory the events of a feed older than before
+func (fs *FileEventStore) truncate(feed string, before time.Time) {
+	list := fs.feeds[feed]
+	i := 0
+	for i < len(list) && list[i].ev.ts.Before(before) {
+		list[i].segment.live--
+		i++
+	}
+	if i == len(list) {
+		delete(fs.feeds, feed)
+		return
+	}
+	fs.feeds[feed] = append([]storedEvent(nil), list[i:]...)
+}
+
+// removeDeadSegments deletes the oldest segments without live events. The
+// current segment is never deleted.
+func (fs *FileEventStore) removeDeadSegments() error {
+	for len(fs.segments) > 1 && fs.segments[0].live == 0 {
+		if err := os.Remove(fs.segments[0].path); err != nil {
+			return err
+		}
+		fs.segments = fs.segments[1:]
+		if err := fs.syncDir(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir syncs the store directory, so the segment files created or
+// removed survive a crash
+func (fs *FileEventStore) syncDir() error {
+	dir, err := os.Open(fs.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// write encodes a record, appends it to the current segment and syncs it
+func (fs *FileEventStore) write(rec fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(data))
+
+	// Rotate the segment if it is full
+	if fs.size > 0 && fs.size+int64(len(header)+len(data)) > fileStoreSegmentSize {
+		if err := fs.file.Close(); err != nil {
+			return err
+		}
+		if err := fs.openSegment(fs.segments[len(fs.segments)-1].seq + 1); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fs.file.Write(append(header, data...)); err != nil {
+		return err
+	}
+	if err := fs.file.Sync(); err != nil {
+		return err
+	}
+	fs.size += int64(len(header) + len(data))
+	return nil
+}
+
+// openSegment creates a new segment file and makes it the current one
+func (fs *FileEventStore) openSegment(seq uint64) error {
+	path := filepath.Join(fs.dir, fmt.Sprintf("%016d.seg", seq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := fs.syncDir(); err != nil {
+		f.Close()
+		return err
+	}
+	fs.file = f
+	fs.size = 0
+	fs.segments = append(fs.segments, &fileSegment{seq: seq, path: path})
+	return nil
+}
+
+// recover replays the segments found in the store directory. A partial or
+// corrupted record at the end of the last segment (eg: a crash during a
+// write) is discarded and the file is truncated.
+func (fs *FileEventStore) recover() error {
+	paths, err := filepath.Glob(filepath.Join(fs.dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for i, path := range paths {
+		var seq uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(filepath.Base(path), ".seg"), "%d", &seq); err != nil {
+			return errors.New("unexpected segment file " + path)
+		}
+		segment := &fileSegment{seq: seq, path: path}
+		fs.segments = append(fs.segments, segment)
+
+		valid, err := fs.replay(segment)
+		if err == nil {
+			continue
+		}
+		if i != len(paths)-1 {
+			return fmt.Errorf("segment %s is corrupted: %s", path, err)
+		}
+		if err := os.Truncate(path, valid); err != nil {
+			return err
+		}
+	}
+
+	return fs.removeDeadSegments()
+}
+
+// replay reads the records of a segment, returning the size of the valid part
+func (fs *FileEventStore) replay(segment *fileSegment) (int64, error) {
+	f, err := os.Open(segment.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var valid int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return valid, nil
+			}
+			return valid, err
+		}
+		// A corrupted length must not be trusted before the checksum
+		length := binary.BigEndian.Uint32(header[0:4])
+		if int64(length) > fileStoreSegmentSize {
+			return valid, errors.New("record too big")
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return valid, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+			return valid, errors.New("checksum mismatch")
+		}
+
+		var rec fileRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return valid, err
+		}
+		switch rec.Op {
+		case recordAppend:
+			ev := &Event{
+				id:      uuid(rec.ID),
+				seq:     rec.Seq,
+				feed:    rec.Feed,
+				ts:      rec.TS,
+				payload: rec.Payload,
+				size:    len(rec.Payload),
+			}
+			segment.live++
+			fs.feeds[rec.Feed] = append(fs.feeds[rec.Feed], storedEvent{ev, segment})
+		case recordTruncate:
+			fs.truncate(rec.Feed, rec.Before)
+		}
+		if rec.Seq > fs.lastID {
+			fs.lastID = rec.Seq
+		}
+		valid += int64(len(header) + len(data))
+	}
+}
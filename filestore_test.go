@@ -0,0 +1,227 @@
+package lp
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func appendEvents(t *testing.T, fs *FileEventStore, feed string, ts time.Time, seqs ...uint64) {
+	t.Helper()
+	for _, seq := range seqs {
+		ev := &Event{id: uuid("id"), seq: seq, feed: feed, ts: ts, payload: map[string]interface{}{"n": seq}}
+		if err := fs.Append(ev); err != nil {
+			t.Fatalf("append %d: %s", seq, err)
+		}
+	}
+}
+
+func readSeqs(t *testing.T, fs *FileEventStore, feed string) []uint64 {
+	t.Helper()
+	events, err := fs.Read(feed, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	seqs := make([]uint64, 0, len(events))
+	for _, ev := range events {
+		seqs = append(seqs, ev.seq)
+	}
+	return seqs
+}
+
+func equalSeqs(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFileEventStoreRecover(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, fs, "f", time.Now(), 1, 2, 3)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{1, 2, 3}) {
+		t.Fatalf("recovered %v, want [1 2 3]", seqs)
+	}
+	if lastID, _ := fs.LastID(); lastID != 3 {
+		t.Fatalf("last id %d, want 3", lastID)
+	}
+	events, _ := fs.Read("f", time.Time{}, time.Time{})
+	if string(events[0].payload.(json.RawMessage)) != `{"n":1}` {
+		t.Fatalf("recovered payload %s", events[0].payload)
+	}
+}
+
+func TestFileEventStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, fs, "f", time.Now(), 1, 2)
+	path := fs.segments[len(fs.segments)-1].path
+	fs.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := info.Size()
+
+	tails := map[string][]byte{
+		"partial header": {0, 0},
+		"partial record": {0, 0, 0, 50, 1, 2, 3, 4, '{', '"'},
+		"bad checksum":   {0, 0, 0, 2, 0, 0, 0, 0, '{', '}'},
+	}
+	for name, tail := range tails {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tail)
+		f.Close()
+
+		fs, err := NewFileEventStore(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{1, 2}) {
+			t.Fatalf("%s: recovered %v, want [1 2]", name, seqs)
+		}
+		fs.Close()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != valid {
+			t.Fatalf("%s: segment size %d, want %d", name, info.Size(), valid)
+		}
+	}
+
+	// The store is writable after the recovery
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, fs, "f", time.Now(), 3)
+	fs.Close()
+
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{1, 2, 3}) {
+		t.Fatalf("recovered %v, want [1 2 3]", seqs)
+	}
+}
+
+func TestFileEventStoreTruncate(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	appendEvents(t, fs, "f", now.Add(-time.Hour), 1, 2)
+	appendEvents(t, fs, "g", now.Add(-time.Hour), 3)
+	appendEvents(t, fs, "f", now, 4)
+
+	if err := fs.Truncate("f", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{4}) {
+		t.Fatalf("truncated %v, want [4]", seqs)
+	}
+	fs.Close()
+
+	// The truncation is replayed
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{4}) {
+		t.Fatalf("recovered %v, want [4]", seqs)
+	}
+	if seqs := readSeqs(t, fs, "g"); !equalSeqs(seqs, []uint64{3}) {
+		t.Fatalf("recovered %v, want [3]", seqs)
+	}
+}
+
+func TestFileEventStoreLastIDAfterSegmentRemoval(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	appendEvents(t, fs, "f", now, 1, 2, 3)
+
+	// The truncate record rotates the segment, the old one is deleted
+	fs.size = fileStoreSegmentSize
+	if err := fs.Truncate("f", now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.segments) != 1 {
+		t.Fatalf("%d segments, want 1", len(fs.segments))
+	}
+	fs.Close()
+
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if lastID, _ := fs.LastID(); lastID != 3 {
+		t.Fatalf("last id %d, want 3", lastID)
+	}
+}
+
+func TestFileEventStoreCorruptedLength(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, fs, "f", time.Now(), 1)
+	path := fs.segments[len(fs.segments)-1].path
+	fs.Close()
+
+	// A header declaring a 4 GiB record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	f.Close()
+
+	fs, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if seqs := readSeqs(t, fs, "f"); !equalSeqs(seqs, []uint64{1}) {
+		t.Fatalf("recovered %v, want [1]", seqs)
+	}
+}
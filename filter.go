@@ -0,0 +1,510 @@
+package lp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter expressions select the events delivered to a subscription. They
+// are evaluated on the event metadata (feed, id, ts) and on the JSON
+// reppresentation of the payload (payload.<dotted.path>):
+//
+//	payload.status == "failed" && payload.amount > 100
+//	feed in ("orders", "refunds") || !exists(payload.test)
+//	payload.user.country != 'IT' and not (id < 1000)
+//
+// Operators: == != < <= > >= in, exists(path), && (and), || (or), ! (not)
+// and parentheses. Literals: strings, numbers, true, false, null and lists.
+// Comparing a missing path is false (but != is true).
+
+// Filter is a compiled filter expression
+type Filter struct {
+	expression string
+	root       filterNode
+}
+
+// filterNode is a node of the expression tree
+type filterNode interface {
+	eval(ev *filterEvent) bool
+}
+
+// filterEvent is the view of an event used by the filters
+type filterEvent struct {
+	ev      *Event
+	payload interface{}
+	decoded bool
+}
+
+// CompileFilter parses a filter expression
+func CompileFilter(expression string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("filter: unexpected %q", p.peek().text)
+	}
+	return &Filter{expression, root}, nil
+}
+
+// Match returns true if the event matches the filter. Control events
+// always match.
+func (f *Filter) Match(ev *Event) bool {
+	return f.match(&filterEvent{ev: ev})
+}
+
+// match evaluates the filter on an event view, so the decoded payload can
+// be shared by the subscriptions of a feed
+func (f *Filter) match(fe *filterEvent) bool {
+	if f == nil || fe.ev.control != "" {
+		return true
+	}
+	return f.root.eval(fe)
+}
+
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// value returns the value of a path: feed, id, ts or payload[.path]
+func (fe *filterEvent) value(path []string) (interface{}, bool) {
+	switch path[0] {
+	case "feed":
+		return fe.ev.feed, len(path) == 1
+	case "id":
+		return float64(fe.ev.seq), len(path) == 1
+	case "ts":
+		return fe.ev.ts.Format("2006-01-02T15:04:05.000000000Z07:00"), len(path) == 1
+	case "payload":
+		// The payload is decoded once, as generic JSON
+		if !fe.decoded {
+			fe.decoded = true
+			if encoded, err := json.Marshal(fe.ev.payload); err == nil {
+				json.Unmarshal(encoded, &fe.payload)
+			}
+		}
+		current := fe.payload
+		for _, key := range path[1:] {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = object[key]; !ok {
+				return nil, false
+			}
+		}
+		return current, true
+	}
+	return nil, false
+}
+
+// Nodes
+
+type filterAnd struct{ left, right filterNode }
+type filterOr struct{ left, right filterNode }
+type filterNot struct{ node filterNode }
+type filterExists struct{ path []string }
+type filterCompare struct {
+	path  []string
+	op    string
+	value interface{}
+}
+type filterIn struct {
+	path   []string
+	values []interface{}
+}
+
+func (n filterAnd) eval(fe *filterEvent) bool { return n.left.eval(fe) && n.right.eval(fe) }
+func (n filterOr) eval(fe *filterEvent) bool  { return n.left.eval(fe) || n.right.eval(fe) }
+func (n filterNot) eval(fe *filterEvent) bool { return !n.node.eval(fe) }
+
+func (n filterExists) eval(fe *filterEvent) bool {
+	_, exists := fe.value(n.path)
+	return exists
+}
+
+func (n filterCompare) eval(fe *filterEvent) bool {
+	value, exists := fe.value(n.path)
+	if !exists {
+		return n.op == "!="
+	}
+
+	switch n.op {
+	case "==":
+		return filterEqual(value, n.value)
+	case "!=":
+		return !filterEqual(value, n.value)
+	}
+
+	// Ordering works on numbers and on strings
+	var cmp int
+	switch v := value.(type) {
+	case float64:
+		other, ok := n.value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case v < other:
+			cmp = -1
+		case v > other:
+			cmp = 1
+		}
+	case string:
+		other, ok := n.value.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(v, other)
+	default:
+		return false
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (n filterIn) eval(fe *filterEvent) bool {
+	value, exists := fe.value(n.path)
+	if !exists {
+		return false
+	}
+	for _, v := range n.values {
+		if filterEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEqual compares two scalar JSON values
+func filterEqual(a interface{}, b interface{}) bool {
+	switch a.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return a == b
+}
+
+// Tokenizer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(' || r == '[':
+			tokens = append(tokens, filterToken{tokenLParen, string(r)})
+			i++
+		case r == ')' || r == ']':
+			tokens = append(tokens, filterToken{tokenRParen, string(r)})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{tokenComma, ","})
+			i++
+
+		case r == '"' || r == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, errors.New("filter: unterminated string")
+			}
+			tokens = append(tokens, filterToken{tokenString, sb.String()})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || strings.ContainsRune(".eE+-", runes[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenNumber, string(runes[i:j])})
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.' || runes[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenIdent, string(runes[i:j])})
+			i = j
+
+		default:
+			// Operators, two characters first
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, filterToken{tokenOp, two})
+					i += 2
+					continue
+				}
+			}
+			switch r {
+			case '<', '>', '!':
+				tokens = append(tokens, filterToken{tokenOp, string(r)})
+				i++
+				continue
+			case '=':
+				tokens = append(tokens, filterToken{tokenOp, "=="})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("filter: unexpected character %q", r)
+		}
+	}
+
+	return append(tokens, filterToken{tokenEOF, ""}), nil
+}
+
+// Parser
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is checks if a token is an operator or a (case insensitive) keyword
+func (t filterToken) is(words ...string) bool {
+	for _, w := range words {
+		if (t.kind == tokenOp || t.kind == tokenIdent) && strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// or := and { ("||" | "or") and }
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("||", "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+	return left, nil
+}
+
+// and := unary { ("&&" | "and") unary }
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("&&", "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+	return left, nil
+}
+
+// unary := ("!" | "not") unary | "(" or ")" | "exists" "(" path ")" | comparison
+func (p *filterParser) parseUnary() (filterNode, error) {
+	t := p.peek()
+
+	if t.is("!", "not") {
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+
+	if t.kind == tokenLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, errors.New("filter: missing )")
+		}
+		return node, nil
+	}
+
+	if t.is("exists") && p.tokens[p.pos+1].kind == tokenLParen {
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, errors.New("filter: missing )")
+		}
+		return filterExists{path}, nil
+	}
+
+	return p.parseComparison()
+}
+
+// comparison := path op literal | path ["not"] "in" list
+func (p *filterParser) parseComparison() (filterNode, error) {
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+
+	negate := false
+	if p.peek().is("not") {
+		p.next()
+		negate = true
+		if !p.peek().is("in") {
+			return nil, errors.New("filter: expected in after not")
+		}
+	}
+
+	op := p.next()
+	if op.is("in") {
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		var node filterNode = filterIn{path, values}
+		if negate {
+			node = filterNot{node}
+		}
+		return node, nil
+	}
+
+	if op.kind != tokenOp || op.text == "!" || op.text == "&&" || op.text == "||" {
+		return nil, fmt.Errorf("filter: expected operator after %s", strings.Join(path, "."))
+	}
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return filterCompare{path, op.text, value}, nil
+}
+
+// path := "feed" | "id" | "ts" | "payload" { "." key }
+func (p *filterParser) parsePath() ([]string, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, fmt.Errorf("filter: expected a path, found %q", t.text)
+	}
+	path := strings.Split(t.text, ".")
+	switch path[0] {
+	case "feed", "id", "ts", "payload":
+		return path, nil
+	}
+	return nil, fmt.Errorf("filter: unknown path %s (use feed, id, ts or payload.<key>)", t.text)
+}
+
+// list := "(" literal { "," literal } ")"
+func (p *filterParser) parseList() ([]interface{}, error) {
+	if p.next().kind != tokenLParen {
+		return nil, errors.New("filter: expected a list after in")
+	}
+	values := make([]interface{}, 0)
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		}
+		if t.kind != tokenComma {
+			return nil, errors.New("filter: expected , or ) in list")
+		}
+	}
+}
+
+// literal := string | number | "true" | "false" | "null"
+func (p *filterParser) parseLiteral() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("filter: not valid number %s", t.text)
+		}
+		return n, nil
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("filter: expected a value, found %q", t.text)
+}
@@ -0,0 +1,94 @@
+package lp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	ev := &Event{
+		seq:  1500,
+		feed: "orders",
+		ts:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		payload: map[string]interface{}{
+			"status": "failed",
+			"amount": 250,
+			"test":   false,
+			"user":   map[string]interface{}{"country": "FR"},
+			"tags":   []string{"a"},
+		},
+	}
+
+	tests := []struct {
+		expression string
+		match      bool
+	}{
+		{`payload.status == "failed"`, true},
+		{`payload.status = "failed"`, true},
+		{`payload.status == 'failed' && payload.amount > 100`, true},
+		{`payload.status == "failed" and payload.amount > 300`, false},
+		{`payload.amount >= 250 && payload.amount <= 250`, true},
+		{`payload.amount < 250 || payload.amount != 250`, false},
+		{`payload.user.country != 'IT' and not (id < 1000)`, true},
+		{`feed in ("orders", "refunds")`, true},
+		{`feed not in ("orders", "refunds")`, false},
+		{`payload.amount in (100, 250)`, true},
+		{`exists(payload.user.country)`, true},
+		{`!exists(payload.missing)`, true},
+		{`payload.missing == 1`, false},
+		{`payload.missing != 1`, true},
+		{`payload.test == false`, true},
+		{`payload.user == null`, false},
+		{`ts > "2024-05-01T00:00:00.000000000Z"`, true},
+		{`!(payload.status == "failed" || feed == "x") || id == 1500`, true},
+		{`payload.status == "failed" || payload.amount > 1000 && feed == "x"`, true},
+		{`(payload.status == "failed" || payload.amount > 1000) && feed == "x"`, false},
+	}
+
+	for _, test := range tests {
+		filter, err := CompileFilter(test.expression)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expression, err)
+		}
+		if match := filter.Match(ev); match != test.match {
+			t.Errorf("%s: match %v, want %v", test.expression, match, test.match)
+		}
+	}
+}
+
+func TestFilterControlEvents(t *testing.T) {
+	filter, err := CompileFilter(`payload.x == 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Match(&Event{feed: "f", control: ControlFeedClosed}) {
+		t.Fatal("control events must always match")
+	}
+
+	var nilFilter *Filter
+	if !nilFilter.Match(&Event{feed: "f"}) {
+		t.Fatal("a nil filter must match every event")
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	expressions := []string{
+		``,
+		`payload.x ==`,
+		`payload.x == 1 &&`,
+		`(payload.x == 1`,
+		`payload.x == 1)`,
+		`payload.x == "open`,
+		`payload.x in 1`,
+		`payload.x in (1, 2`,
+		`exists(payload.x`,
+		`unknown.x == 1`,
+		`payload.x == 1 payload.y == 2`,
+	}
+
+	for _, expression := range expressions {
+		if _, err := CompileFilter(expression); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
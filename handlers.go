@@ -4,38 +4,74 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-func sendInternalError(w http.ResponseWriter) {
-	if recovered := recover(); recovered != nil {
-		log.Println(recovered)
-		SendError(w, 500, "internal server error")
+// CreateFeed creates a new feed in the system
+func (b *Broker) CreateFeed(w http.ResponseWriter, r *http.Request) {
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	// Check feeds
+	feeds := extractFeeds(r)
+	if len(feeds) == 0 {
+		b.sendError(w, r, 400, "missing valid feed(s)")
+		return
+	}
+	if len(feeds) > 1 {
+		b.sendError(w, r, 400, "to many feeds")
+		return
+	}
+
+	if IsFeedPattern(feeds[0]) {
+		b.sendError(w, r, 400, "feed name can not contain wildcards")
+		return
+	}
+
+	if !b.authorize(w, r, ActionCreate, feeds) {
+		return
 	}
+
+	_, err := b.NewFeed(feeds[0])
+	if err != nil {
+		b.sendError(w, r, 500, "can not create feed "+feeds[0])
+		return
+	}
+
+	SendOK(w)
+	return
 }
 
-// CreateFeed creates a new feed in the system
-func CreateFeed(w http.ResponseWriter, r *http.Request) {
+// DeleteFeedHandler deletes a feed from the system
+func (b *Broker) DeleteFeedHandler(w http.ResponseWriter, r *http.Request) {
 	// Send an internal error in case of panic.
-	defer sendInternalError(w)
+	defer b.sendInternalError(w, r)
 
 	// Check feeds
 	feeds := extractFeeds(r)
 	if len(feeds) == 0 {
-		SendError(w, 400, "missing valid feed(s)")
+		b.sendError(w, r, 400, "missing valid feed(s)")
 		return
 	}
 	if len(feeds) > 1 {
-		SendError(w, 400, "to many feeds")
+		b.sendError(w, r, 400, "to many feeds")
 		return
 	}
 
-	_, err := NewFeed(feeds[0])
+	if !b.authorize(w, r, ActionAdmin, feeds) {
+		return
+	}
+
+	if _, err := b.GetFeedFromName(feeds[0]); err != nil {
+		b.sendError(w, r, 404, err.Error())
+		return
+	}
+
+	err := b.DeleteFeed(feeds[0])
 	if err != nil {
-		SendError(w, 500, "can not create feed "+feeds[0])
+		b.sendError(w, r, 500, "can not delete feed "+feeds[0])
 		return
 	}
 
@@ -44,141 +80,380 @@ func CreateFeed(w http.ResponseWriter, r *http.Request) {
 }
 
 // SubscribeHandler is the handler to be use to listen for subscriptions
-func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+func (b *Broker) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Send an internal error in case of panic.
-	defer sendInternalError(w)
+	defer b.sendInternalError(w, r)
 
-	// Check feeds
-	feeds := getFeeds(r)
-	if len(feeds) == 0 {
-		SendError(w, 400, "missing valid feed(s)")
+	// Check feeds and feed patterns
+	feeds := b.getFeeds(r)
+	patterns, err := extractPatterns(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	if len(feeds) == 0 && len(patterns) == 0 {
+		b.sendError(w, r, 400, "missing valid feed(s)")
+		return
+	}
+
+	if !b.authorize(w, r, ActionSubscribe, extractFeeds(r)) {
+		return
+	}
+
+	// No new subscriptions during the shutdown
+	if b.isShuttingDown() {
+		b.sendShutdown(w, r)
+		return
+	}
+
+	// Subscription options
+	opts, err := extractSubscriptionOptions(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+
+	// Bind the subscription to its creator
+	opts.Owner = b.principal(r)
+	if b.opts.BindSubscriptions && opts.Owner == "" {
+		b.sendError(w, r, 401, "subscriptions are bound to their creator, the client must be identified")
 		return
 	}
 
 	// Create a new connection
-	subscription := NewSubscription()
+	subscription := b.NewSubscriptionWithOptions(opts)
 
 	// Subscribe the feeds
 	for _, feed := range feeds {
 		subscription.Subscribe(feed)
 	}
+	for _, pattern := range patterns {
+		b.SubscribePattern(subscription, pattern)
+	}
 
-	// Prepare the feed uid list
-	feedUUIDs := make([]string, 0)
-	for _, f := range feeds {
-		feedUUIDs = append(feedUUIDs, f.name)
+	// Start from the past events, if requested
+	last, since, err := extractHistory(r)
+	if err != nil {
+		b.CloseSubscription(subscription)
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	if last > 0 || !since.IsZero() {
+		if err := b.ReplayHistory(subscription, last, since); err != nil {
+			b.CloseSubscription(subscription)
+			b.sendError(w, r, 500, "can not read events")
+			return
+		}
 	}
 
+	sendSubscription(w, subscription)
+
+	return
+}
+
+// sendSubscription returns the subscription id, its feed list and its feed
+// patterns
+func sendSubscription(w http.ResponseWriter, subscription *Subscription) {
 	resp := struct {
 		Feeds          []string
+		Patterns       []string `json:",omitempty"`
 		SubscriptionID string
 	}{
-		feedUUIDs,
+		subscription.FeedNames(),
+		subscription.Patterns(),
 		string(subscription.id),
 	}
 	SendResponse(w, resp)
-
-	return
 }
 
 // ListenHandler is the handler to be use to listen for events
-func ListenHandler(w http.ResponseWriter, r *http.Request) {
+func (b *Broker) ListenHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Send an internal error in case of panic.
-	defer sendInternalError(w)
+	defer b.sendInternalError(w, r)
 
 	subscriptionID := extractSubscription(r)
-	subscription, err := GetSubscription(subscriptionID)
+	subscription, err := b.GetSubscription(subscriptionID)
 	if err != nil {
-		SendError(w, 403, "not valid subscriptionID")
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	if !b.authorize(w, r, ActionListen, subscriptionFeeds(subscription)) {
+		return
+	}
+
+	if ok, wait := b.limitListen(r, subscription); !ok {
+		b.sendRateLimited(w, r, wait)
 		return
 	}
 
-	// Check if there are listening connections
-	subscription.l.Lock()
-	if subscription.listening {
-		// Send an abort signal to previous listening connection
-		subscription.signal <- stateAbort
+	// Replay the retained events newer than the client cursor
+	if after, ok := extractAfter(r); ok {
+		if err := b.resume(subscription, after); err != nil {
+			b.sendError(w, r, 500, "can not read events")
+			return
+		}
 	}
-	// Set there is an active listening connection
-	subscription.listening = true
-	subscription.l.Unlock()
 
-	// Check one or more event are ready in the queue. It uses a goroutine
-	// so the business logic (wait for events) could be unified
-	go subscription.CheckForEvents()
+	// Acknowledge the events received with the previous response
+	ids, err := extractIDs(r, "ack")
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	subscription.Ack(ids...)
 
 	// Timeout
 	timeout := extractTimeout(r)
 	if timeout == 0 {
-		timeout = 30
+		timeout = b.opts.Timeout
+	}
+
+	// Batching parameters
+	batch, err := b.extractBatch(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
 	}
 
+	// Streaming mode: the response stays open until the timeout
+	if extractStream(r) == "ndjson" {
+		b.streamNDJSON(w, r, subscription, timeout)
+		return
+	}
+
+	// Attach this connection as listener. A previous listening connection
+	// is aborted. If events are ready in the queue the listener is notified
+	// immediately, so the business logic (wait for events) is unified.
+	signal := subscription.listen()
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
 	// Wait for some signal...
 	select {
 
 	// A message is sent in the communication channel
-	case signal := <-subscription.signal:
+	case st := <-signal:
 
-		// Events are sent in the communication channel
-		if signal == stateReady {
-			events := subscription.GetEvents()
+		// Wait a bit for more events, so they are sent in a single response
+		if st == stateReady && batch.linger > 0 {
+			st = subscription.linger(signal, batch.linger, deadline, batch.maxEvents)
+		}
 
-			subscription.l.Lock()
-			subscription.listening = false
-			subscription.l.Unlock()
-			SendEvents(w, events)
+		// Events are sent in the communication channel
+		if st == stateReady {
+			events, dropped := subscription.takeEvents(batch.maxEvents, batch.maxBytes)
+			subscription.release(signal)
+			b.metrics.listen(listenEvents)
+			if err := sendEvents(w, events, dropped); err == nil {
+				b.hooks.delivered(subscription, events)
+			}
 			return
 		}
 
 		// An abort signal is sent to the communication channel
-		if signal == stateAbort {
-			SendError(w, 500, "ABORTED")
+		if st == stateAbort {
+			b.metrics.listen(listenAbort)
+			b.sendError(w, r, 500, "ABORTED")
+			return
+		}
+
+		// The subscription has been closed
+		if st == stateClosed {
+			b.metrics.listen(listenClosed)
+			b.sendError(w, r, 410, "subscription closed")
+			return
+		}
+
+		// The subscription queue overflowed
+		if st == stateOverflow {
+			b.metrics.listen(listenOverflow)
+			b.CloseSubscription(subscription)
+			b.sendError(w, r, 410, "subscription terminated: queue overflow")
+			return
+		}
+
+		// The broker is shutting down, the client reconnects
+		if st == stateShutdown {
+			b.metrics.listen(listenShutdown)
+			b.sendShutdown(w, r)
 			return
 		}
 
 	// Timeout is triggered
-	case <-time.After(time.Duration(timeout) * time.Second):
-		subscription.l.Lock()
-		subscription.listening = false
+	case <-timer.C:
+		subscription.release(signal)
+		b.metrics.listen(listenTimeout)
+		b.hooks.listenTimedOut(subscription)
 		SendTimeout(w)
-		subscription.l.Unlock()
 		return
 	}
 
 	return
 }
 
+// AddFeedsHandler subscribes an existing subscription to more feeds
+func (b *Broker) AddFeedsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	// Check feeds
+	feeds := b.getFeeds(r)
+	patterns, err := extractPatterns(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	if len(feeds) == 0 && len(patterns) == 0 {
+		b.sendError(w, r, 400, "missing valid feed(s)")
+		return
+	}
+
+	if !b.authorize(w, r, ActionSubscribe, extractFeeds(r)) {
+		return
+	}
+
+	// Subscribe the feeds, already subscribed feeds are ignored
+	for _, feed := range feeds {
+		subscription.Subscribe(feed)
+	}
+	for _, pattern := range patterns {
+		b.SubscribePattern(subscription, pattern)
+	}
+
+	sendSubscription(w, subscription)
+	return
+}
+
+// RemoveFeedsHandler unsubscribes an existing subscription from some feeds
+func (b *Broker) RemoveFeedsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	// Check feeds
+	feeds := b.getFeeds(r)
+	patterns, err := extractPatterns(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	if len(feeds) == 0 && len(patterns) == 0 {
+		b.sendError(w, r, 400, "missing valid feed(s)")
+		return
+	}
+
+	if !b.authorize(w, r, ActionSubscribe, extractFeeds(r)) {
+		return
+	}
+
+	for _, feed := range feeds {
+		subscription.Unsubscribe(feed)
+	}
+	for _, pattern := range patterns {
+		b.UnsubscribePattern(subscription, pattern)
+	}
+
+	sendSubscription(w, subscription)
+	return
+}
+
+// CloseSubscriptionHandler closes an existing subscription, detaching it
+// from all its feeds and freeing its queue
+func (b *Broker) CloseSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	if !b.authorize(w, r, ActionSubscribe, subscriptionFeeds(subscription)) {
+		return
+	}
+
+	b.CloseSubscription(subscription)
+
+	SendOK(w)
+	return
+}
+
 // NotifyEvent notify a new event
-func NotifyEvent(w http.ResponseWriter, r *http.Request) {
+func (b *Broker) NotifyEvent(w http.ResponseWriter, r *http.Request) {
 
 	// Send an internal error in case of panic.
-	defer sendInternalError(w)
+	defer b.sendInternalError(w, r)
 
 	// Check feeds
-	feeds := getFeeds(r)
+	feeds := b.getFeeds(r)
 	if len(feeds) == 0 {
-		SendError(w, 400, "missing valid feed(s)")
+		b.sendError(w, r, 400, "missing valid feed(s)")
 		return
 	}
 	if len(feeds) > 1 {
-		SendError(w, 400, "too many feeds")
+		b.sendError(w, r, 400, "too many feeds")
+		return
+	}
+
+	if !b.authorize(w, r, ActionPublish, []string{feeds[0].name}) {
+		return
+	}
+
+	if ok, wait := b.limitPublish(r, feeds[0]); !ok {
+		b.sendRateLimited(w, r, wait)
 		return
 	}
 
 	bodyString, err := getBodyString(r)
 	if err != nil {
-		SendError(w, 400, "can not parse body")
+		b.sendError(w, r, 400, "can not parse body")
+		return
 	}
-	payload, err := parserFunction(bodyString)
+	payload, err := b.parseEvent(bodyString)
 	if err != nil {
-		SendError(w, 500, "missing event parser function")
+		b.sendError(w, r, 500, "missing event parser function")
+		return
 	}
 
-	_, newEventError := NewEvent(feeds[0], payload)
+	_, newEventError := b.NewEvent(feeds[0], payload)
 	if newEventError != nil {
-		SendError(w, 500, fmt.Sprintf("%s", newEventError))
+		b.sendError(w, r, 500, fmt.Sprintf("%s", newEventError))
 		return
 	}
 
@@ -196,13 +471,13 @@ func getBodyString(r *http.Request) (string, error) {
 	return string(b), nil
 }
 
-func getFeeds(r *http.Request) []*Feed {
+func (b *Broker) getFeeds(r *http.Request) []*Feed {
 	var feeds = make([]*Feed, 0)
 
 	feedNames := extractFeeds(r)
 	if len(feedNames) > 0 {
 		for _, feedName := range feedNames {
-			feed, err := GetFeedFromName(feedName)
+			feed, err := b.GetFeedFromName(feedName)
 			if err == nil {
 				feeds = append(feeds, feed)
 			}
@@ -211,6 +486,21 @@ func getFeeds(r *http.Request) []*Feed {
 	return feeds
 }
 
+// extractPatterns returns the feed parameters containing wildcards
+func extractPatterns(r *http.Request) ([]string, error) {
+	patterns := make([]string, 0)
+	for _, feedName := range extractFeeds(r) {
+		if !IsFeedPattern(feedName) {
+			continue
+		}
+		if err := validFeedPattern(feedName); err != nil {
+			return patterns, err
+		}
+		patterns = append(patterns, feedName)
+	}
+	return patterns, nil
+}
+
 func extractFeeds(r *http.Request) []string {
 	var feedsNames = make([]string, 0)
 
@@ -256,3 +546,86 @@ func extractTimeout(r *http.Request) int {
 	// Search in body
 	// TODO
 }
+
+func extractAfter(r *http.Request) (uint64, bool) {
+	var ok bool
+
+	// Search in URL
+	afterStrings, ok := r.URL.Query()["after"]
+	if ok == true && len(afterStrings) == 1 {
+		if after, err := strconv.ParseUint(afterStrings[0], 10, 64); err == nil {
+			return after, true
+		}
+	}
+	return 0, false
+}
+
+func extractSubscriptionOptions(r *http.Request) (SubscriptionOptions, error) {
+	var opts SubscriptionOptions
+	query := r.URL.Query()
+
+	if maxQueue := query.Get("maxqueue"); maxQueue != "" {
+		var err error
+		if opts.MaxQueue, err = strconv.Atoi(maxQueue); err != nil || opts.MaxQueue < 0 {
+			return opts, errors.New("not valid maxqueue")
+		}
+	}
+
+	overflow, err := parseOverflowPolicy(query.Get("overflow"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Overflow = overflow
+
+	if ack := query.Get("ack"); ack != "" {
+		if opts.Ack, err = strconv.ParseBool(ack); err != nil {
+			return opts, errors.New("not valid ack")
+		}
+	}
+
+	if ackTimeout := query.Get("acktimeout"); ackTimeout != "" {
+		seconds, err := strconv.Atoi(ackTimeout)
+		if err != nil || seconds < 0 {
+			return opts, errors.New("not valid acktimeout")
+		}
+		opts.AckTimeout = time.Duration(seconds) * time.Second
+	}
+
+	if filter := query.Get("filter"); filter != "" {
+		if opts.Filter, err = CompileFilter(filter); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// extractHistory returns the last and since parameters. since can be a
+// RFC3339 timestamp or a unix time in seconds.
+func extractHistory(r *http.Request) (last int, since time.Time, err error) {
+	query := r.URL.Query()
+
+	if lastString := query.Get("last"); lastString != "" {
+		if last, err = strconv.Atoi(lastString); err != nil || last < 0 {
+			return 0, since, errors.New("not valid last")
+		}
+	}
+
+	if sinceString := query.Get("since"); sinceString != "" {
+		if since, err = time.Parse(time.RFC3339Nano, sinceString); err == nil {
+			return last, since, nil
+		}
+		unix, err := strconv.ParseInt(sinceString, 10, 64)
+		if err != nil {
+			return last, since, errors.New("not valid since")
+		}
+		since = time.Unix(unix, 0)
+	}
+
+	return last, since, nil
+}
+
+func extractStream(r *http.Request) string {
+	// Search in URL
+	return r.URL.Query().Get("stream")
+}
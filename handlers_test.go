@@ -0,0 +1,56 @@
+package lp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// subscriptionRequest calls a subscription handler and decodes the reply
+func subscriptionRequest(t *testing.T, b *Broker, path string, query string) (int, []string) {
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, httptest.NewRequest("GET", path+"?"+query, nil))
+	var resp struct{ Feeds []string }
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp.Feeds
+}
+
+func TestSubscriptionHandlers(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	for _, name := range []string{"a", "b", "c"} {
+		b.NewFeed(name)
+	}
+	s := b.NewSubscription()
+	id := "subscriptionID=" + s.ID()
+
+	if code, feeds := subscriptionRequest(t, b, "/subscription/add", id+"&feed=a&feed=b&feed=missing"); code != 200 || len(feeds) != 2 {
+		t.Fatalf("add: %d %v, want [a b]", code, feeds)
+	}
+	if code, feeds := subscriptionRequest(t, b, "/subscription/remove", id+"&feed=a"); code != 200 || len(feeds) != 1 || feeds[0] != "b" {
+		t.Fatalf("remove: %d %v, want [b]", code, feeds)
+	}
+	if code, _ := subscriptionRequest(t, b, "/subscription/add", id); code != 400 {
+		t.Fatalf("add without feeds: %d, want 400", code)
+	}
+	if code, _ := subscriptionRequest(t, b, "/subscription/add", "subscriptionID=unknown&feed=a"); code != 403 {
+		t.Fatalf("add to an unknown subscription: %d, want 403", code)
+	}
+
+	// A closed subscription is not found anymore
+	signal := s.listen()
+	if code, _ := subscriptionRequest(t, b, "/subscription/close", id); code != 200 {
+		t.Fatalf("close: %d", code)
+	}
+	if st := <-signal; st != stateClosed {
+		t.Fatalf("listener woken with %d, want %d", st, stateClosed)
+	}
+	if code, _ := subscriptionRequest(t, b, "/subscription/add", id+"&feed=a"); code != 403 {
+		t.Fatalf("add to a closed subscription: %d, want 403", code)
+	}
+}
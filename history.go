@@ -0,0 +1,107 @@
+package lp
+
+import (
+	"sort"
+	"time"
+)
+
+// ReplayHistory queues again in a subscription the retained events of its
+// feeds published since the since timestamp (if not zero), limited to the
+// last ones (if last is not zero). Feeds created with FeedOptions.History
+// keep their events even without subscribers, so a late subscriber can
+// fetch the past events.
+func (b *Broker) ReplayHistory(s *Subscription, last int, since time.Time) error {
+	replay, err := b.storedEvents(s.FeedNames(), since, 0)
+	if err != nil {
+		return err
+	}
+	if last > 0 && len(replay) > last {
+		replay = replay[len(replay)-last:]
+	}
+
+	s.requeue(replay, 0)
+	return nil
+}
+
+// resume rebuilds the subscription queue from the cursor after: the
+// retained events of the subscribed feeds newer than after are queued again
+// and the queued events not newer than after (already received by the
+// client) are dropped
+func (b *Broker) resume(s *Subscription, after uint64) error {
+	replay, err := b.storedEvents(s.FeedNames(), time.Time{}, after)
+	if err != nil {
+		return err
+	}
+
+	s.requeue(replay, after)
+	return nil
+}
+
+// storedEvents returns the stored events of some feeds published since the
+// since timestamp and newer than after, sorted by id
+func (b *Broker) storedEvents(feedNames []string, since time.Time, after uint64) ([]*Event, error) {
+	list := make([]*Event, 0)
+	for _, feedName := range feedNames {
+		events, err := b.store.Read(feedName, since, time.Time{})
+		if err != nil {
+			return list, err
+		}
+		for _, ev := range events {
+			if ev.seq > after {
+				list = append(list, ev)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	return list, nil
+}
+
+// requeue merges the replayed events in the subscription queue, dropping
+// the queued events not newer than after and the duplicates. The queue is
+// rebuilt applying the overflow policy.
+func (s *Subscription) requeue(replay []*Event, after uint64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	// A terminated subscription waits to be closed
+	if s.overflowed {
+		return
+	}
+
+	// Replayed events are filtered as the published ones
+	filtered := make([]*Event, 0, len(replay))
+	queued := make(map[uint64]bool)
+	for _, ev := range replay {
+		if s.opts.Filter.Match(ev) {
+			filtered = append(filtered, ev)
+			queued[ev.seq] = true
+		}
+	}
+	replay = filtered
+
+	controls := make([]*Event, 0)
+	for _, ev := range s.events {
+		// Control events are not numbered, keep them at the end
+		if ev.seq == 0 {
+			controls = append(controls, ev)
+			continue
+		}
+		if ev.seq > after && !queued[ev.seq] {
+			replay = append(replay, ev)
+		}
+	}
+	sort.Slice(replay, func(i, j int) bool { return replay[i].seq < replay[j].seq })
+
+	s.events = make([]*Event, 0, len(replay)+len(controls))
+	for _, ev := range append(replay, controls...) {
+		if !s.enqueue(ev) {
+			s.terminate(stateOverflow)
+			return
+		}
+	}
+
+	// Wake the listener, if any
+	if len(s.events) > 0 {
+		s.signal(stateReady)
+	}
+}
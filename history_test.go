@@ -0,0 +1,134 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventOrder(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				b.NewEvent(feed, j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The timestamps follow the ids, so a time range of the store never
+	// skips an id
+	events, _ := b.store.Read("f", time.Time{}, time.Time{})
+	for i := 1; i < len(events); i++ {
+		if events[i].seq <= events[i-1].seq || events[i].ts.Before(events[i-1].ts) {
+			t.Fatalf("event %d (%s) stored after %d (%s)", events[i].seq, events[i].ts, events[i-1].seq, events[i-1].ts)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	for i := 0; i < 5; i++ {
+		b.NewEvent(feed, i)
+	}
+
+	// The client received the first 3 events, but the response was lost
+	received, _ := s.takeEvents(3, 0)
+	b.NewEvent(feed, 5)
+	if err := b.resume(s, received[0].seq); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := eventSeqs(s.GetEvents()); !equalSeqs(seqs, []uint64{2, 3, 4, 5, 6}) {
+		t.Fatalf("queued %v, want [2 3 4 5 6]", seqs)
+	}
+
+	// Queued events already received are dropped, without duplicates
+	b.NewEvent(feed, 6)
+	b.NewEvent(feed, 7)
+	if err := b.resume(s, 7); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := eventSeqs(s.GetEvents()); !equalSeqs(seqs, []uint64{8}) {
+		t.Fatalf("queued %v, want [8]", seqs)
+	}
+}
+
+func TestReplayHistory(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	other, _ := b.NewFeedWithOptions("other", FeedOptions{History: true})
+	for i := 0; i < 3; i++ {
+		b.NewEvent(feed, i)
+		b.NewEvent(other, i)
+	}
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	b.NewEvent(feed, 3)
+
+	tests := []struct {
+		name  string
+		last  int
+		since time.Time
+		want  []uint64
+	}{
+		{"all", 0, time.Time{}, []uint64{1, 3, 5, 7}},
+		{"last", 2, time.Time{}, []uint64{5, 7}},
+		{"since", 0, since, []uint64{7}},
+		{"last and since", 3, since, []uint64{7}},
+	}
+
+	for _, test := range tests {
+		s := b.NewSubscription()
+		s.Subscribe(feed)
+		if err := b.ReplayHistory(s, test.last, test.since); err != nil {
+			t.Fatal(err)
+		}
+		if seqs := eventSeqs(s.GetEvents()); !equalSeqs(seqs, test.want) {
+			t.Errorf("%s: replayed %v, want %v", test.name, seqs, test.want)
+		}
+	}
+}
+
+func TestExtractHistory(t *testing.T) {
+	tests := []struct {
+		query string
+		last  int
+		since time.Time
+		valid bool
+	}{
+		{"", 0, time.Time{}, true},
+		{"last=10", 10, time.Time{}, true},
+		{"since=1714564800", 0, time.Unix(1714564800, 0), true},
+		{"since=2024-05-01T12:00:00Z&last=2", 2, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"last=-1", 0, time.Time{}, false},
+		{"last=x", 0, time.Time{}, false},
+		{"since=yesterday", 0, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/?"+test.query, nil)
+		last, since, err := extractHistory(r)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v", test.query, err)
+			continue
+		}
+		if test.valid && (last != test.last || !since.Equal(test.since)) {
+			t.Errorf("%s: last %d since %s, want %d and %s", test.query, last, since, test.last, test.since)
+		}
+	}
+}
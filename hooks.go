@@ -0,0 +1,186 @@
+package lp
+
+import (
+	"sync"
+)
+
+// hooks holds the callbacks registered on the broker lifecycle events.
+// They are called synchronously, in registration order.
+type hooks struct {
+	l               sync.Mutex
+	onFeedCreated   []func(f *Feed)
+	onSubscribe     []func(s *Subscription, f *Feed)
+	onUnsubscribe   []func(s *Subscription, f *Feed)
+	onPublish       []func(ev *Event) error
+	onDeliver       []func(s *Subscription, events []*Event)
+	onListenTimeout []func(s *Subscription)
+	onExpire        []func(s *Subscription)
+}
+
+// OnFeedCreated registers a hook called when a feed is created
+func (b *Broker) OnFeedCreated(hook func(f *Feed)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onFeedCreated = append(b.hooks.onFeedCreated, hook)
+}
+
+// OnSubscribe registers a hook called when a subscription subscribes a feed
+// (pattern subscriptions included)
+func (b *Broker) OnSubscribe(hook func(s *Subscription, f *Feed)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onSubscribe = append(b.hooks.onSubscribe, hook)
+}
+
+// OnUnsubscribe registers a hook called when a subscription unsubscribes a
+// feed, also when the subscription is closed or the feed deleted
+func (b *Broker) OnUnsubscribe(hook func(s *Subscription, f *Feed)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onUnsubscribe = append(b.hooks.onUnsubscribe, hook)
+}
+
+// OnPublish registers a hook called before an event is stored and sent to
+// the subscribers. The event id and timestamp are not assigned yet. The
+// hook can change the payload (Event.SetPayload) or reject the event
+// returning an error, that is returned by NewEvent.
+func (b *Broker) OnPublish(hook func(ev *Event) error) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onPublish = append(b.hooks.onPublish, hook)
+}
+
+// OnDeliver registers a hook called after some events have been sent to
+// the client of a subscription
+func (b *Broker) OnDeliver(hook func(s *Subscription, events []*Event)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onDeliver = append(b.hooks.onDeliver, hook)
+}
+
+// OnListenTimeout registers a hook called when a listen request times out
+// without events
+func (b *Broker) OnListenTimeout(hook func(s *Subscription)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onListenTimeout = append(b.hooks.onListenTimeout, hook)
+}
+
+// OnExpire registers a hook called when an idle subscription is expired
+// (see Options.SubscriptionTTL)
+func (b *Broker) OnExpire(hook func(s *Subscription)) {
+	b.hooks.l.Lock()
+	defer b.hooks.l.Unlock()
+
+	b.hooks.onExpire = append(b.hooks.onExpire, hook)
+}
+
+func (h *hooks) feedCreatedHooks() []func(f *Feed) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onFeedCreated
+}
+
+func (h *hooks) subscribeHooks() []func(s *Subscription, f *Feed) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onSubscribe
+}
+
+func (h *hooks) unsubscribeHooks() []func(s *Subscription, f *Feed) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onUnsubscribe
+}
+
+func (h *hooks) publishHooks() []func(ev *Event) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onPublish
+}
+
+func (h *hooks) deliverHooks() []func(s *Subscription, events []*Event) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onDeliver
+}
+
+func (h *hooks) listenTimeoutHooks() []func(s *Subscription) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onListenTimeout
+}
+
+func (h *hooks) expireHooks() []func(s *Subscription) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.onExpire
+}
+
+// feedCreated calls the OnFeedCreated hooks
+func (h *hooks) feedCreated(f *Feed) {
+	for _, hook := range h.feedCreatedHooks() {
+		hook(f)
+	}
+}
+
+// subscribed calls the OnSubscribe hooks
+func (h *hooks) subscribed(s *Subscription, f *Feed) {
+	for _, hook := range h.subscribeHooks() {
+		hook(s, f)
+	}
+}
+
+// unsubscribed calls the OnUnsubscribe hooks
+func (h *hooks) unsubscribed(s *Subscription, f *Feed) {
+	for _, hook := range h.unsubscribeHooks() {
+		hook(s, f)
+	}
+}
+
+// publishing calls the OnPublish hooks, stopping at the first error
+func (h *hooks) publishing(ev *Event) error {
+	for _, hook := range h.publishHooks() {
+		if err := hook(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delivered calls the OnDeliver hooks
+func (h *hooks) delivered(s *Subscription, events []*Event) {
+	if len(events) == 0 {
+		return
+	}
+	for _, hook := range h.deliverHooks() {
+		hook(s, events)
+	}
+}
+
+// listenTimedOut calls the OnListenTimeout hooks
+func (h *hooks) listenTimedOut(s *Subscription) {
+	for _, hook := range h.listenTimeoutHooks() {
+		hook(s)
+	}
+}
+
+// expired calls the OnExpire hooks
+func (h *hooks) expired(s *Subscription) {
+	for _, hook := range h.expireHooks() {
+		hook(s)
+	}
+}
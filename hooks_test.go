@@ -0,0 +1,55 @@
+package lp
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	calls := make([]string, 0)
+	b.OnFeedCreated(func(f *Feed) { calls = append(calls, "created "+f.name) })
+	b.OnSubscribe(func(s *Subscription, f *Feed) { calls = append(calls, "subscribed "+f.name) })
+	b.OnUnsubscribe(func(s *Subscription, f *Feed) { calls = append(calls, "unsubscribed "+f.name) })
+	b.OnDeliver(func(s *Subscription, events []*Event) {
+		calls = append(calls, "delivered "+events[0].Payload().(string))
+	})
+	b.OnPublish(func(ev *Event) error {
+		if ev.Payload() == "secret" {
+			return errors.New("rejected")
+		}
+		ev.SetPayload(strings.ToUpper(ev.Payload().(string)))
+		return nil
+	})
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	if _, err := b.NewEvent(feed, "secret"); err == nil || err.Error() != "rejected" {
+		t.Fatalf("error %v, want the hook one", err)
+	}
+	b.NewEvent(feed, "hello")
+	b.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/listen?subscriptionID="+s.ID(), nil))
+	b.CloseSubscription(s)
+
+	want := []string{"created f", "subscribed f", "delivered HELLO", "unsubscribed f"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("calls %v, want %v", calls, want)
+	}
+}
+
+func TestDeliverHookSkipsEmptyBatches(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	called := false
+	b.OnDeliver(func(s *Subscription, events []*Event) { called = true })
+	b.hooks.delivered(b.NewSubscription(), nil)
+	if called {
+		t.Fatal("deliver hook called without events")
+	}
+}
@@ -1,8 +0,0 @@
-package lp
-
-import (
-	"math/rand"
-	"time"
-)
-
-var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -0,0 +1,76 @@
+package lp
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Logger is the leveled, structured logger used by the broker and the SDK.
+// args are key/value pairs (eg: "feed", name). *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// DiscardLogger is a Logger that discards every message, to silence the
+// library
+var DiscardLogger Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, args ...interface{}) {}
+func (discardLogger) Info(msg string, args ...interface{})  {}
+func (discardLogger) Warn(msg string, args ...interface{})  {}
+func (discardLogger) Error(msg string, args ...interface{}) {}
+
+// sendError sends an error response and logs it: server errors at error
+// level, refused requests at debug level
+func (b *Broker) sendError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	args := append([]interface{}{"status", code, "error", message}, requestFields(r)...)
+	if code >= 500 {
+		b.logger.Error("request failed", args...)
+	} else {
+		b.logger.Debug("request refused", args...)
+	}
+	SendError(w, code, message)
+}
+
+// sendInternalError sends an internal error in case of panic. It must be
+// deferred.
+func (b *Broker) sendInternalError(w http.ResponseWriter, r *http.Request) {
+	if recovered := recover(); recovered != nil {
+		b.logger.Error("handler panic", append([]interface{}{"panic", recovered}, requestFields(r)...)...)
+		SendError(w, 500, "internal server error")
+	}
+}
+
+// requestFields returns the log fields of a request
+func requestFields(r *http.Request) []interface{} {
+	fields := []interface{}{"method", r.Method, "path", r.URL.Path}
+	query := r.URL.Query()
+	if subscriptionID := query.Get("subscriptionID"); subscriptionID != "" {
+		fields = append(fields, "subscriptionID", subscriptionID)
+	}
+	if feeds := query["feed"]; len(feeds) == 1 {
+		fields = append(fields, "feed", feeds[0])
+	} else if len(feeds) > 1 {
+		fields = append(fields, "feeds", feeds)
+	}
+	return fields
+}
+
+// defaultLogger returns the slog default logger
+func defaultLogger() Logger {
+	return slog.Default()
+}
+
+// LogRequest logs each request, at info level, with the broker Logger
+func (b *Broker) LogRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.Context().Value("sessionID")
+		b.logger.Info("request", "method", r.Method, "sessionID", sessionID, "uri", r.RequestURI)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package lp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger is a Logger keeping the messages
+type recordingLogger struct {
+	l        sync.Mutex
+	messages []string
+}
+
+func (rl *recordingLogger) log(level string, msg string, args ...interface{}) {
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	rl.messages = append(rl.messages, strings.TrimSpace(level+" "+msg+" "+fmt.Sprint(args...)))
+}
+
+func (rl *recordingLogger) Debug(msg string, args ...interface{}) { rl.log("DEBUG", msg, args...) }
+func (rl *recordingLogger) Info(msg string, args ...interface{})  { rl.log("INFO", msg, args...) }
+func (rl *recordingLogger) Warn(msg string, args ...interface{})  { rl.log("WARN", msg, args...) }
+func (rl *recordingLogger) Error(msg string, args ...interface{}) { rl.log("ERROR", msg, args...) }
+
+// find returns the first message starting with prefix
+func (rl *recordingLogger) find(prefix string) string {
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	for _, message := range rl.messages {
+		if strings.HasPrefix(message, prefix) {
+			return message
+		}
+	}
+	return ""
+}
+
+func TestBrokerLogger(t *testing.T) {
+	logger := new(recordingLogger)
+	b := NewBroker(Options{Logger: logger})
+	defer b.Close()
+
+	// Refused requests are logged at debug level, with the request fields
+	w := httptest.NewRecorder()
+	b.ListenHandler(w, httptest.NewRequest("GET", "/listen?subscriptionID=unknown", nil))
+	if message := logger.find("DEBUG request refused"); !strings.Contains(message, "403") || !strings.Contains(message, "unknown") {
+		t.Fatalf("refused request logged as %q", message)
+	}
+
+	// Panics are logged at error level and answered with a 500
+	w = httptest.NewRecorder()
+	func() {
+		defer b.sendInternalError(w, httptest.NewRequest("GET", "/", nil))
+		panic("boom")
+	}()
+	if w.Code != 500 || !strings.Contains(logger.find("ERROR handler panic"), "boom") {
+		t.Fatalf("panic answered %d, logged as %q", w.Code, logger.find("ERROR handler panic"))
+	}
+
+	// LogRequest uses the broker logger
+	handler := b.LogRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if message := logger.find("INFO request"); !strings.Contains(message, "/metrics") {
+		t.Fatalf("request logged as %q", message)
+	}
+}
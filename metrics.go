@@ -0,0 +1,211 @@
+package lp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listen outcomes
+const (
+	listenEvents   = "events"
+	listenTimeout  = "timeout"
+	listenAbort    = "abort"
+	listenClosed   = "closed"
+	listenOverflow = "overflow"
+	listenShutdown = "shutdown"
+)
+
+var (
+	queueDepthBuckets      = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000}
+	deliveryLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+)
+
+// metrics collects the broker counters. They are exposed in the Prometheus
+// text format by MetricsHandler.
+type metrics struct {
+	l         sync.Mutex
+	published map[string]uint64
+	delivered map[string]uint64
+	dropped   map[string]uint64
+	listens   map[string]uint64
+	latency   *histogram
+}
+
+func newMetrics() *metrics {
+	m := new(metrics)
+	m.published = make(map[string]uint64)
+	m.delivered = make(map[string]uint64)
+	m.dropped = make(map[string]uint64)
+	m.listens = make(map[string]uint64)
+	m.latency = newHistogram(deliveryLatencyBuckets)
+	return m
+}
+
+// eventPublished counts an event published in a feed
+func (m *metrics) eventPublished(feedName string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.published[feedName]++
+}
+
+// eventsDelivered counts the events sent to a client and observes their
+// publish to delivery latency
+func (m *metrics) eventsDelivered(events []*Event, now time.Time) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for _, e := range events {
+		if e.control != "" {
+			continue
+		}
+		m.delivered[e.feed]++
+		m.latency.observe(now.Sub(e.ts).Seconds())
+	}
+}
+
+// eventDropped counts an event dropped from a subscription queue
+func (m *metrics) eventDropped(e *Event) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.dropped[e.feed]++
+}
+
+// listen counts a long poll outcome
+func (m *metrics) listen(outcome string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.listens[outcome]++
+}
+
+// removeFeed forgets the counters of a deleted feed
+func (m *metrics) removeFeed(feedName string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	delete(m.published, feedName)
+	delete(m.delivered, feedName)
+	delete(m.dropped, feedName)
+}
+
+// histogram is a Prometheus histogram with fixed buckets
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// write writes the histogram series (the bucket counts are cumulative)
+func (h *histogram) write(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// WriteMetrics writes the broker metrics in the Prometheus text format
+func (b *Broker) WriteMetrics(w io.Writer) {
+	b.l.Lock()
+	feeds := len(b.feeds)
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.l.Unlock()
+
+	// Listeners and queue depths are computed at scrape time: the histogram
+	// buckets count the subscriptions by queue length
+	listeners := 0
+	depth := newHistogram(queueDepthBuckets)
+	for _, s := range subscriptions {
+		s.l.Lock()
+		if s.listener != nil {
+			listeners++
+		}
+		depth.observe(float64(len(s.events)))
+		s.l.Unlock()
+	}
+
+	writeGauge(w, "lp_feeds", "Number of feeds.", feeds)
+	writeGauge(w, "lp_subscriptions", "Number of subscriptions.", len(subscriptions))
+	writeGauge(w, "lp_listeners", "Number of connected listeners.", listeners)
+
+	m := b.metrics
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	writeCounters(w, "lp_events_published_total", "Events published, by feed.", "feed", m.published)
+	writeCounters(w, "lp_events_delivered_total", "Events delivered to the clients, by feed.", "feed", m.delivered)
+	writeCounters(w, "lp_events_dropped_total", "Events dropped from the subscription queues, by feed.", "feed", m.dropped)
+	writeCounters(w, "lp_listen_requests_total", "Long poll requests, by outcome.", "outcome", m.listens)
+	depth.write(w, "lp_queue_depth", "Number of queued events of the subscriptions.")
+	m.latency.write(w, "lp_delivery_latency_seconds", "Time between the publishing and the delivery of the events.")
+}
+
+// MetricsHandler exposes the broker metrics in the Prometheus text format
+func (b *Broker) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	if !b.authorize(w, r, ActionAdmin, nil) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	b.WriteMetrics(w)
+	return
+}
+
+func writeGauge(w io.Writer, name string, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+// writeCounters writes a counter with a label, sorted by label value
+func writeCounters(w io.Writer, name string, help string, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
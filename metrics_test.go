@@ -0,0 +1,65 @@
+package lp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQueueDepthHistogram(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("f")
+	for _, queued := range []int{0, 1, 3, 20} {
+		s := b.NewSubscription()
+		s.Subscribe(feed)
+		for i := 0; i < queued; i++ {
+			s.NotifyEvent(&Event{seq: uint64(i + 1), feed: "f"})
+		}
+	}
+
+	var out bytes.Buffer
+	b.WriteMetrics(&out)
+	for _, want := range []string{
+		"# TYPE lp_queue_depth histogram",
+		`lp_queue_depth_bucket{le="0"} 1`,
+		`lp_queue_depth_bucket{le="1"} 2`,
+		`lp_queue_depth_bucket{le="5"} 3`,
+		`lp_queue_depth_bucket{le="10"} 3`,
+		`lp_queue_depth_bucket{le="50"} 4`,
+		`lp_queue_depth_bucket{le="+Inf"} 4`,
+		"lp_queue_depth_sum 24",
+		"lp_queue_depth_count 4",
+		"lp_subscriptions 4",
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsCounters(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed(`f"x`)
+	s := b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 1, Overflow: OverflowDropOldest})
+	s.Subscribe(feed)
+	b.NewEvent(feed, 1)
+	b.NewEvent(feed, 2)
+	s.GetEvents()
+
+	var out bytes.Buffer
+	b.WriteMetrics(&out)
+	for _, want := range []string{
+		`lp_events_published_total{feed="f\"x"} 2`,
+		`lp_events_dropped_total{feed="f\"x"} 1`,
+		`lp_events_delivered_total{feed="f\"x"} 1`,
+		"lp_delivery_latency_seconds_count 1",
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package lp
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Feed names are hierarchical: their tokens are separated by "." or "/"
+// (eg: orders.eu.created or orders/eu/created). A pattern can subscribe many
+// feeds at once: "*" matches exactly one token and ">", allowed only as last
+// token, matches one or more tokens. orders.*.created matches
+// orders.eu.created and orders.us.created; orders.> matches every feed under
+// orders. Pattern subscriptions pick up the feeds created later, too.
+
+const (
+	wildcardToken = "*"
+	wildcardTail  = ">"
+)
+
+// splitFeedName returns the tokens of a feed name or pattern
+func splitFeedName(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '/'
+	})
+}
+
+// IsFeedPattern returns true if the name contains wildcard tokens
+func IsFeedPattern(name string) bool {
+	for _, token := range splitFeedName(name) {
+		if token == wildcardToken || token == wildcardTail {
+			return true
+		}
+	}
+	return false
+}
+
+// validFeedPattern checks that ">" is used only as last token
+func validFeedPattern(pattern string) error {
+	tokens := splitFeedName(pattern)
+	if len(tokens) == 0 {
+		return errors.New("empty feed pattern")
+	}
+	for i, token := range tokens {
+		if token == wildcardTail && i != len(tokens)-1 {
+			return errors.New("not valid feed pattern " + pattern + ": " + wildcardTail + " must be the last token")
+		}
+	}
+	return nil
+}
+
+// MatchFeedPattern returns true if a feed name matches a pattern
+func MatchFeedPattern(pattern string, name string) bool {
+	patternTokens := splitFeedName(pattern)
+	nameTokens := splitFeedName(name)
+
+	for i, token := range patternTokens {
+		if token == wildcardTail {
+			return len(nameTokens) > i
+		}
+		if i >= len(nameTokens) {
+			return false
+		}
+		if token != wildcardToken && token != nameTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(nameTokens)
+}
+
+// SubscribePattern subscribes a subscription to all the feeds matching a
+// pattern, including the feeds that will be created later
+func (b *Broker) SubscribePattern(s *Subscription, pattern string) error {
+	if err := validFeedPattern(pattern); err != nil {
+		return err
+	}
+
+	// The pattern is registered before looking for the feeds, so a feed
+	// created meanwhile is subscribed by NewFeed or here
+	s.l.Lock()
+	s.patterns[pattern] = true
+	s.l.Unlock()
+
+	for _, f := range b.matchingFeeds(pattern) {
+		s.subscribe(f, false)
+	}
+	return nil
+}
+
+// UnsubscribePattern removes a pattern from a subscription and unsubscribes
+// the feeds matching it, unless they are subscribed by name or matched by
+// another pattern of the subscription
+func (b *Broker) UnsubscribePattern(s *Subscription, pattern string) error {
+	s.l.Lock()
+	delete(s.patterns, pattern)
+	s.l.Unlock()
+
+	for _, f := range b.matchingFeeds(pattern) {
+		if s.covers(f) {
+			continue
+		}
+		s.Unsubscribe(f)
+	}
+	return nil
+}
+
+// matchingFeeds returns the feeds matching a pattern
+func (b *Broker) matchingFeeds(pattern string) []*Feed {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	feeds := make([]*Feed, 0)
+	for _, f := range b.feeds {
+		if MatchFeedPattern(pattern, f.name) {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds
+}
+
+// matchingSubscriptions returns the subscriptions with a pattern matching a
+// feed name. It must be called holding the broker lock.
+func (b *Broker) matchingSubscriptions(feedName string) []*Subscription {
+	list := make([]*Subscription, 0)
+	for _, s := range b.subscriptions {
+		if s.matches(feedName) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// matches returns true if one of the subscription patterns matches a feed
+// name
+func (s *Subscription) matches(feedName string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for pattern := range s.patterns {
+		if MatchFeedPattern(pattern, feedName) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers returns true if a feed is subscribed by name or matched by one of
+// the subscription patterns
+func (s *Subscription) covers(f *Feed) bool {
+	s.l.Lock()
+	named := s.named[f.id]
+	s.l.Unlock()
+
+	return named || s.matches(f.name)
+}
+
+// Patterns returns the feed patterns of the subscription
+func (s *Subscription) Patterns() []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	patterns := make([]string, 0, len(s.patterns))
+	for pattern := range s.patterns {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
@@ -0,0 +1,143 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchFeedPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders/eu/created", true},
+		{"orders.*.created", "orders.eu.deleted", false},
+		{"orders.*.created", "orders.created", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.>", "orders.eu", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{"*", "orders", true},
+		{"*", "orders.eu", false},
+		{">", "orders.eu", true},
+		{"orders.eu", "orders.eu", true},
+		{"orders.eu", "orders.us", false},
+	}
+
+	for _, test := range tests {
+		if match := MatchFeedPattern(test.pattern, test.name); match != test.match {
+			t.Errorf("%s %s: match %v, want %v", test.pattern, test.name, match, test.match)
+		}
+	}
+}
+
+func TestValidFeedPattern(t *testing.T) {
+	valid := []string{"orders.*", "orders.>", "*.created", ">", "a/*/c"}
+	for _, pattern := range valid {
+		if err := validFeedPattern(pattern); err != nil {
+			t.Errorf("%s: %s", pattern, err)
+		}
+	}
+
+	notValid := []string{"", ".", "orders.>.created", ">.orders"}
+	for _, pattern := range notValid {
+		if err := validFeedPattern(pattern); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
+
+func TestIsFeedPattern(t *testing.T) {
+	if !IsFeedPattern("orders.*") || !IsFeedPattern("orders/>") {
+		t.Fatal("wildcards not detected")
+	}
+	if IsFeedPattern("orders.eu") || IsFeedPattern("orders*") {
+		t.Fatal("plain names detected as patterns")
+	}
+}
+
+func TestSubscribePattern(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	eu, _ := b.NewFeed("orders.eu")
+	s := b.NewSubscription()
+	if err := b.SubscribePattern(s, "orders.*"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing and new matching feeds are subscribed
+	us, _ := b.NewFeed("orders.us")
+	b.NewFeed("refunds.eu")
+	if names := s.FeedNames(); len(names) != 2 || names[0] != "orders.eu" || names[1] != "orders.us" {
+		t.Fatalf("subscribed %v, want [orders.eu orders.us]", names)
+	}
+
+	b.NewEvent(eu, 1)
+	b.NewEvent(us, 2)
+	if events := s.GetEvents(); len(events) != 2 {
+		t.Fatalf("%d events, want 2", len(events))
+	}
+
+	if _, err := b.NewFeed("orders.*"); err == nil {
+		t.Fatal("a feed name can not be a pattern")
+	}
+}
+
+func TestUnsubscribePattern(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	eu, _ := b.NewFeed("orders.eu")
+	b.NewFeed("orders.us")
+	b.NewFeed("orders.eu.created")
+	s := b.NewSubscription()
+	s.Subscribe(eu)
+	b.SubscribePattern(s, "orders.*")
+	b.SubscribePattern(s, "orders.>")
+
+	// The feeds matched by another pattern stay subscribed
+	b.UnsubscribePattern(s, "orders.*")
+	if names := s.FeedNames(); len(names) != 3 {
+		t.Fatalf("subscribed %v, want the 3 feeds matched by orders.>", names)
+	}
+
+	// The feeds subscribed by name stay subscribed
+	b.UnsubscribePattern(s, "orders.>")
+	if names := s.FeedNames(); len(names) != 1 || names[0] != "orders.eu" {
+		t.Fatalf("subscribed %v, want [orders.eu]", names)
+	}
+	if patterns := s.Patterns(); len(patterns) != 0 {
+		t.Fatalf("patterns %v, want none", patterns)
+	}
+}
+
+func TestSDKUpdateFeedsPatterns(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	srv := httptest.NewServer(b.Handler())
+	defer srv.Close()
+
+	b.NewFeed("orders.eu")
+	b.NewFeed("refunds")
+	s := b.NewSubscription()
+
+	sdk := &SDK{subscriptionID: s.ID()}
+	sdk.setServerURL(srv.URL)
+	if err := sdk.AddFeeds("orders.*", "refunds"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new subscription with Feeds picks up the feeds created later
+	want := []string{"orders.eu", "refunds", "orders.*"}
+	if len(sdk.Feeds) != len(want) {
+		t.Fatalf("feeds %v, want %v", sdk.Feeds, want)
+	}
+	for i := range want {
+		if sdk.Feeds[i] != want[i] {
+			t.Fatalf("feeds %v, want %v", sdk.Feeds, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package lp
+
+import "errors"
+
+// OverflowPolicy is what happens when an event is notified to a
+// subscription with a full queue
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest drops the oldest queued event (default)
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest drops the notified event
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowTerminate terminates the subscription. The client receives an
+	// error on its next listen.
+	OverflowTerminate OverflowPolicy = "terminate"
+)
+
+func parseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch OverflowPolicy(policy) {
+	case "":
+		return "", nil
+	case OverflowDropOldest, OverflowDropNewest, OverflowTerminate:
+		return OverflowPolicy(policy), nil
+	}
+	return "", errors.New("unknown overflow policy " + policy)
+}
+
+// queueLimit returns the maximum queue length and the overflow policy of
+// the subscription. Subscription options come first, then the strictest
+// feed options, then the broker defaults.
+// It must be called holding the subscription lock.
+func (s *Subscription) queueLimit() (int, OverflowPolicy) {
+	// The feed with the smallest queue length
+	var strictest *Feed
+	for _, f := range s.feeds {
+		if f.opts.MaxQueue > 0 && (strictest == nil || f.opts.MaxQueue < strictest.opts.MaxQueue) {
+			strictest = f
+		}
+	}
+
+	limit := s.opts.MaxQueue
+	if limit == 0 && strictest != nil {
+		limit = strictest.opts.MaxQueue
+	}
+	if limit == 0 {
+		limit = s.defaults.MaxQueue
+	}
+
+	policy := s.opts.Overflow
+	if policy == "" && strictest != nil {
+		policy = strictest.opts.Overflow
+	}
+	if policy == "" {
+		policy = s.defaults.Overflow
+	}
+	if policy == "" {
+		policy = OverflowDropOldest
+	}
+	return limit, policy
+}
+
+// enqueue appends an event to the queue, applying the overflow policy.
+// It returns false if the subscription has been terminated.
+// It must be called holding the subscription lock.
+func (s *Subscription) enqueue(e *Event) bool {
+	// Control events are always delivered
+	if e.control != "" {
+		s.events = append(s.events, e)
+		return true
+	}
+
+	limit, policy := s.queueLimit()
+	if limit > 0 && len(s.events) >= limit {
+		switch policy {
+		case OverflowDropNewest:
+			s.dropped++
+			s.metrics.eventDropped(e)
+			return true
+		case OverflowTerminate:
+			for _, queued := range s.events {
+				s.metrics.eventDropped(queued)
+			}
+			s.metrics.eventDropped(e)
+			s.overflowed = true
+			s.events = make([]*Event, 0)
+			return false
+		default:
+			s.metrics.eventDropped(s.events[0])
+			s.events = s.events[1:]
+			s.dropped++
+		}
+	}
+
+	s.events = append(s.events, e)
+	return true
+}
@@ -0,0 +1,124 @@
+package lp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy     OverflowPolicy
+		queued     []uint64
+		dropped    int
+		overflowed bool
+	}{
+		{OverflowDropOldest, []uint64{3, 4}, 2, false},
+		{OverflowDropNewest, []uint64{1, 2}, 2, false},
+		{OverflowTerminate, []uint64{}, 0, true},
+	}
+
+	for _, test := range tests {
+		b := NewBroker(Options{Logger: DiscardLogger})
+		feed, _ := b.NewFeed("f")
+		s := b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 2, Overflow: test.policy})
+		s.Subscribe(feed)
+		for i := 0; i < 4; i++ {
+			b.NewEvent(feed, i)
+		}
+
+		if s.overflowed != test.overflowed {
+			t.Errorf("%s: overflowed %v, want %v", test.policy, s.overflowed, test.overflowed)
+		}
+		events, dropped := s.takeEvents(0, 0)
+		if seqs := eventSeqs(events); !equalSeqs(seqs, test.queued) || dropped != test.dropped {
+			t.Errorf("%s: queued %v, dropped %d, want %v and %d dropped", test.policy, seqs, dropped, test.queued, test.dropped)
+		}
+		b.Close()
+	}
+}
+
+func TestOverflowTerminateListen(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 1, Overflow: OverflowTerminate})
+	s.Subscribe(feed)
+	listener := s.listen()
+	b.NewEvent(feed, 1)
+	<-listener
+	b.NewEvent(feed, 2)
+
+	select {
+	case st := <-s.listen():
+		if st != stateOverflow {
+			t.Fatalf("state %d, want %d", st, stateOverflow)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener not terminated")
+	}
+
+	// Later events are not queued
+	b.NewEvent(feed, 3)
+	if events := s.GetEvents(); len(events) != 0 {
+		t.Fatalf("queued %v after the overflow", eventSeqs(events))
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger, MaxQueue: 10, Overflow: OverflowDropNewest})
+	defer b.Close()
+
+	loose, _ := b.NewFeedWithOptions("loose", FeedOptions{MaxQueue: 5})
+	strict, _ := b.NewFeedWithOptions("strict", FeedOptions{MaxQueue: 3, Overflow: OverflowTerminate})
+
+	s := b.NewSubscription()
+	if limit, policy := s.queueLimit(); limit != 10 || policy != OverflowDropNewest {
+		t.Fatalf("limit %d %s, want the broker default", limit, policy)
+	}
+	s.Subscribe(loose)
+	s.Subscribe(strict)
+	if limit, policy := s.queueLimit(); limit != 3 || policy != OverflowTerminate {
+		t.Fatalf("limit %d %s, want the strictest feed one", limit, policy)
+	}
+
+	s = b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 7})
+	s.Subscribe(strict)
+	if limit, policy := s.queueLimit(); limit != 7 || policy != OverflowTerminate {
+		t.Fatalf("limit %d %s, want the subscription one", limit, policy)
+	}
+}
+
+func TestReplayOverflow(t *testing.T) {
+	tests := []struct {
+		policy     OverflowPolicy
+		queued     []uint64
+		overflowed bool
+	}{
+		{OverflowDropOldest, []uint64{4, 5}, false},
+		{OverflowDropNewest, []uint64{1, 2}, false},
+		{OverflowTerminate, []uint64{}, true},
+	}
+
+	for _, test := range tests {
+		b := NewBroker(Options{Logger: DiscardLogger})
+		feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+		for i := 0; i < 5; i++ {
+			b.NewEvent(feed, i)
+		}
+
+		s := b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 2, Overflow: test.policy})
+		s.Subscribe(feed)
+		if err := b.ReplayHistory(s, 0, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.overflowed != test.overflowed {
+			t.Errorf("%s: overflowed %v, want %v", test.policy, s.overflowed, test.overflowed)
+		}
+		if seqs := eventSeqs(s.GetEvents()); !equalSeqs(seqs, test.queued) {
+			t.Errorf("%s: queued %v, want %v", test.policy, seqs, test.queued)
+		}
+		b.Close()
+	}
+}
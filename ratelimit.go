@@ -0,0 +1,180 @@
+package lp
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiterSweep is the interval between the removals of the idle buckets
+const rateLimiterSweep = time.Minute
+
+// RateKey is what a rate limit is counted by
+type RateKey string
+
+// Rate limit keys
+const (
+	// RateByPrincipal counts the requests of each principal (the remote
+	// address for anonymous clients)
+	RateByPrincipal RateKey = "principal"
+	// RateByRemoteAddr counts the requests of each remote address
+	RateByRemoteAddr RateKey = "remote"
+	// RateByFeed counts the requests on each feed
+	RateByFeed RateKey = "feed"
+	// RateBySubscription counts the requests on each subscription
+	RateBySubscription RateKey = "subscription"
+)
+
+// RateLimit is a token bucket limit: Rate requests per second with bursts
+// of Burst requests, counted by Key. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	Key   RateKey
+}
+
+// merge returns the limit, using fallback values for the unset fields
+func (rl RateLimit) merge(fallback RateLimit) RateLimit {
+	if rl.Rate == 0 {
+		rl.Rate = fallback.Rate
+	}
+	if rl.Burst == 0 {
+		rl.Burst = fallback.Burst
+	}
+	if rl.Key == "" {
+		rl.Key = fallback.Key
+	}
+	return rl
+}
+
+// tokenBucket is the state of a single limited key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// rateLimiter holds the buckets of the limited keys. Buckets are removed
+// once refilled.
+type rateLimiter struct {
+	l         sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	rl := new(rateLimiter)
+	rl.buckets = make(map[string]*tokenBucket)
+	rl.lastSweep = time.Now()
+	return rl
+}
+
+// allow takes a token from the bucket of a key. If the bucket is empty it
+// returns false and how long to wait for the next token.
+func (rl *rateLimiter) allow(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+
+	if now.Sub(rl.lastSweep) > rateLimiterSweep {
+		for k, b := range rl.buckets {
+			if now.After(b.full) {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, exists := rl.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: burst, last: now}
+		rl.buckets[key] = b
+	}
+
+	// Refill
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((burst - b.tokens) / limit.Rate * float64(time.Second)))
+	return true, 0
+}
+
+// publishLimit returns the publish limit of a feed
+func (b *Broker) publishLimit(feed *Feed) RateLimit {
+	return feed.opts.PublishLimit.merge(b.opts.PublishLimit).merge(RateLimit{Key: RateByFeed})
+}
+
+// limitPublish checks the publish limit of a feed. If the request is
+// limited, it returns false and how long the client should wait.
+func (b *Broker) limitPublish(r *http.Request, feed *Feed) (bool, time.Duration) {
+	limit := b.publishLimit(feed)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	// A feed with its own limit has its own buckets
+	key := "publish:"
+	if feed.opts.PublishLimit.Rate > 0 {
+		key += feed.name + ":"
+	}
+	key += b.rateKey(r, limit.Key, feed.name, "")
+	return b.limiter.allow(key, limit, time.Now())
+}
+
+// limitListen checks the listen limit of a subscription. If the request is
+// limited, it returns false and how long the client should wait.
+func (b *Broker) limitListen(r *http.Request, s *Subscription) (bool, time.Duration) {
+	limit := b.opts.ListenLimit.merge(RateLimit{Key: RateBySubscription})
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	key := "listen:" + b.rateKey(r, limit.Key, "", string(s.id))
+	return b.limiter.allow(key, limit, time.Now())
+}
+
+// rateKey returns the value a limit is counted by
+func (b *Broker) rateKey(r *http.Request, key RateKey, feedName string, subscriptionID string) string {
+	switch key {
+	case RateByPrincipal:
+		if principal := b.principal(r); principal != "" {
+			return "principal:" + principal
+		}
+		return "remote:" + remoteHost(r)
+	case RateByRemoteAddr:
+		return "remote:" + remoteHost(r)
+	}
+	if key == RateByFeed && feedName != "" {
+		return "feed:" + feedName
+	}
+	return "subscription:" + subscriptionID
+}
+
+// remoteHost returns the host of the request remote address
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sendRateLimited answers 429, with the Retry-After header in seconds
+func (b *Broker) sendRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	b.sendError(w, r, 429, "rate limit exceeded")
+}
@@ -0,0 +1,95 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	rl := newRateLimiter()
+	limit := RateLimit{Rate: 2, Burst: 3}
+	now := time.Now()
+
+	// The burst is allowed at once
+	for i := 0; i < 3; i++ {
+		if allowed, _ := rl.allow("k", limit, now); !allowed {
+			t.Fatalf("request %d of the burst limited", i)
+		}
+	}
+	allowed, wait := rl.allow("k", limit, now)
+	if allowed || wait != 500*time.Millisecond {
+		t.Fatalf("allowed %v, wait %s, want limited for 500ms", allowed, wait)
+	}
+
+	// Other keys have their own bucket
+	if allowed, _ := rl.allow("other", limit, now); !allowed {
+		t.Fatal("other key limited")
+	}
+
+	// A token every 1/Rate seconds
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := rl.allow("k", limit, now); !allowed {
+		t.Fatal("refilled token not allowed")
+	}
+	if allowed, _ := rl.allow("k", limit, now); allowed {
+		t.Fatal("allowed more than the refilled tokens")
+	}
+
+	// The bucket does not grow over the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		rl.allow("k", limit, now)
+	}
+	if allowed, _ := rl.allow("k", limit, now); allowed {
+		t.Fatal("allowed more than the burst")
+	}
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	rl := newRateLimiter()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	rl.allow("idle", limit, now)
+	rl.allow("active", limit, now.Add(2*rateLimiterSweep))
+	if _, exists := rl.buckets["idle"]; exists {
+		t.Fatal("refilled bucket not removed")
+	}
+	if _, exists := rl.buckets["active"]; !exists {
+		t.Fatal("active bucket removed")
+	}
+}
+
+func TestLimitPublish(t *testing.T) {
+	b := NewBroker(Options{
+		Logger:       DiscardLogger,
+		PublishLimit: RateLimit{Rate: 1, Burst: 1, Key: RateByRemoteAddr},
+	})
+	defer b.Close()
+
+	shared1, _ := b.NewFeed("shared1")
+	shared2, _ := b.NewFeed("shared2")
+	own, _ := b.NewFeedWithOptions("own", FeedOptions{PublishLimit: RateLimit{Rate: 1, Burst: 2}})
+
+	r := httptest.NewRequest("POST", "/", nil)
+	if allowed, _ := b.limitPublish(r, shared1); !allowed {
+		t.Fatal("first publish limited")
+	}
+	// The feeds without their own limit share the remote address bucket
+	if allowed, _ := b.limitPublish(r, shared2); allowed {
+		t.Fatal("shared bucket not applied")
+	}
+	// Requests from another address are counted apart
+	other := httptest.NewRequest("POST", "/", nil)
+	other.RemoteAddr = "10.0.0.1:1234"
+	if allowed, _ := b.limitPublish(other, shared2); !allowed {
+		t.Fatal("other address limited")
+	}
+	// A feed with its own limit has its own bucket and burst
+	for i := 0; i < 2; i++ {
+		if allowed, _ := b.limitPublish(r, own); !allowed {
+			t.Fatalf("publish %d on the feed with its own limit limited", i)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lp
+
+import (
+	"time"
+)
+
+// reaper periodically expires the idle subscriptions
+func (b *Broker) reaper() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.opts.SubscriptionTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.reap(now)
+		}
+	}
+}
+
+// reap drops the subscriptions without listeners since more than the TTL.
+// The saved subscriptions not restored within the TTL are dropped too.
+func (b *Broker) reap(now time.Time) {
+	b.l.Lock()
+	if len(b.restored) > 0 && now.Sub(b.restoredAt) > b.opts.SubscriptionTTL {
+		b.restored = nil
+	}
+	expired := make([]*Subscription, 0)
+	for _, s := range b.subscriptions {
+		s.l.Lock()
+		if s.listener == nil && now.Sub(s.lastSeen) > b.opts.SubscriptionTTL {
+			expired = append(expired, s)
+		}
+		s.l.Unlock()
+	}
+	b.l.Unlock()
+
+	for _, s := range expired {
+		b.CloseSubscription(s)
+		b.hooks.expired(s)
+	}
+}
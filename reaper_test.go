@@ -0,0 +1,94 @@
+package lp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReap(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger, SubscriptionTTL: time.Minute})
+	defer b.Close()
+
+	expired := make(chan *Subscription, 2)
+	b.OnExpire(func(s *Subscription) { expired <- s })
+
+	idle := b.NewSubscription()
+	listening := b.NewSubscription()
+	signal := listening.listen()
+	defer listening.release(signal)
+
+	b.reap(time.Now())
+	if _, err := b.GetSubscription(idle.id); err != nil {
+		t.Fatal("subscription expired before the TTL")
+	}
+
+	// Only the subscriptions without listeners expire
+	b.reap(time.Now().Add(2 * time.Minute))
+	if _, err := b.GetSubscription(idle.id); err == nil {
+		t.Fatal("idle subscription not expired")
+	}
+	if _, err := b.GetSubscription(listening.id); err != nil {
+		t.Fatal("subscription with a listener expired")
+	}
+	if s := <-expired; s != idle {
+		t.Fatal("expire hook called with another subscription")
+	}
+}
+
+// eventsHandlerFunc is a LongPollClient calling a function
+type eventsHandlerFunc func([]EventData, error) bool
+
+func (f eventsHandlerFunc) EventsHandler(events []EventData, err error) bool {
+	return f(events, err)
+}
+
+func TestSDKSubscribesAgain(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	srv := httptest.NewServer(b.Handler())
+	defer srv.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	sdk := &SDK{Feeds: []string{"f"}, Timeout: 1}
+	sdk.setServerURL(srv.URL)
+
+	received := make([]uint64, 0)
+	done := make(chan error, 1)
+	go func() {
+		done <- sdk.Connect(eventsHandlerFunc(func(events []EventData, err error) bool {
+			if err != nil {
+				t.Errorf("handler called with %v", err)
+				return false
+			}
+			for _, e := range events {
+				received = append(received, e.ID)
+			}
+			if len(received) == 1 {
+				// The subscription expires between two listens, meanwhile
+				// an event is published
+				sdk.l.Lock()
+				s, _ := b.GetSubscription(uuid(sdk.subscriptionID))
+				sdk.l.Unlock()
+				b.CloseSubscription(s)
+				b.NewEvent(feed, 2)
+			}
+			return len(received) < 2
+		}))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	b.NewEvent(feed, 1)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("events not received after the subscription expired")
+	}
+	if !equalSeqs(received, []uint64{1, 2}) {
+		t.Fatalf("received %v, want [1 2]", received)
+	}
+}
@@ -3,7 +3,6 @@ package lp
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 )
@@ -15,23 +14,30 @@ type ErrorResponse struct {
 	Message   string
 }
 
-// EventData is the exported data reppresentation
+// EventData is the exported data reppresentation. ID can be used as cursor
+// (after parameter) to resume listening. Control is set only for the control
+// events (eg: ControlFeedClosed) generated by the broker, that have no ID.
 type EventData struct {
+	ID        uint64
+	Feed      string
 	TimeStamp time.Time
 	Payload   interface{}
+	Control   string `json:",omitempty"`
 }
 
-// EventsData is the final response, in case of event(s)
+// EventsData is the final response, in case of event(s). Dropped is the
+// number of events dropped from the subscription queue since the previous
+// response.
 type EventsData struct {
-	Error  bool
-	Events []EventData
+	Error   bool
+	Events  []EventData
+	Dropped int `json:",omitempty"`
 }
 
 // SendError encode an error as JSON
 func SendError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	log.Printf("ERROR: [%d] %s\n", code, message)
 	json, err := toJSON(ErrorResponse{true, code, message})
 	if err != nil {
 		SendError(w, 500, err.Error())
@@ -74,24 +80,35 @@ func SendTimeout(w http.ResponseWriter) {
 
 // SendEvents returns the events
 func SendEvents(w http.ResponseWriter, events []*Event) {
+	sendEvents(w, events, 0)
+}
+
+func sendEvents(w http.ResponseWriter, events []*Event, dropped int) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 
 	eventsResponse := EventsData{
-		Error:  false,
-		Events: make([]EventData, 0),
+		Error:   false,
+		Events:  make([]EventData, 0),
+		Dropped: dropped,
 	}
 
 	for _, e := range events {
-		eventsResponse.Events = append(eventsResponse.Events, EventData{e.ts, e.payload})
+		eventsResponse.Events = append(eventsResponse.Events, e.data())
 	}
 
 	json, err := toJSON(eventsResponse)
 	if err != nil {
 		SendError(w, 500, err.Error())
-		return
+		return err
 	}
-	fmt.Fprintf(w, json)
+	_, err = fmt.Fprint(w, json)
+	return err
+}
+
+// data returns the exported data reppresentation of an event
+func (e *Event) data() EventData {
+	return EventData{e.seq, e.feed, e.ts, e.payload, e.control}
 }
 
 // SendResponse returns a generic JSON message
@@ -120,12 +137,3 @@ func fromJSON(byt []byte, dataStructure interface{}) error {
 	}
 	return nil
 }
-
-// LogRequest logs each request
-func LogRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		sessionID := r.Context().Value("sessionID")
-		log.Println(r.Method, "-", sessionID, "-", r.RequestURI)
-		next.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,133 @@
+package lp
+
+import (
+	"time"
+)
+
+// Retention is the policy that limits the events kept in the store for a
+// feed. Zero values mean no limit.
+type Retention struct {
+	// MaxAge is the maximum age of an event
+	MaxAge time.Duration
+	// MaxCount is the maximum number of events
+	MaxCount int
+	// MaxBytes is the maximum size of the JSON encoded payloads
+	MaxBytes int
+}
+
+// merge returns the retention, using fallback values for the unset fields
+func (r Retention) merge(fallback Retention) Retention {
+	if r.MaxAge == 0 {
+		r.MaxAge = fallback.MaxAge
+	}
+	if r.MaxCount == 0 {
+		r.MaxCount = fallback.MaxCount
+	}
+	if r.MaxBytes == 0 {
+		r.MaxBytes = fallback.MaxBytes
+	}
+	return r
+}
+
+func (r Retention) isZero() bool {
+	return r.MaxAge == 0 && r.MaxCount == 0 && r.MaxBytes == 0
+}
+
+// cutoff returns the timestamp of the oldest event to keep. A zero time
+// means no event must be removed.
+func (r Retention) cutoff(events []*Event, now time.Time) time.Time {
+	var cutoff time.Time
+
+	if r.MaxAge > 0 {
+		cutoff = now.Add(-r.MaxAge)
+	}
+
+	if r.MaxCount > 0 && len(events) > r.MaxCount {
+		if ts := events[len(events)-r.MaxCount].ts; ts.After(cutoff) {
+			cutoff = ts
+		}
+	}
+
+	if r.MaxBytes > 0 {
+		total := 0
+		for i := len(events) - 1; i >= 0; i-- {
+			total += events[i].size
+			if total <= r.MaxBytes {
+				continue
+			}
+			// events[i] does not fit, keep the newer ones
+			if i+1 < len(events) {
+				if ts := events[i+1].ts; ts.After(cutoff) {
+					cutoff = ts
+				}
+			} else {
+				cutoff = now
+			}
+			break
+		}
+	}
+
+	return cutoff
+}
+
+// compactor periodically enforces the feed retention policies
+func (b *Broker) compactor() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.opts.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.compact(now.UTC())
+		}
+	}
+}
+
+// compact removes from the store the events exceeding the feed retention.
+// The feeds of the store that do not exist in the broker (eg: not created
+// again after a restart) get the default retention, if the store is a
+// FeedLister.
+func (b *Broker) compact(now time.Time) {
+	b.l.Lock()
+	retentions := make(map[string]Retention, len(b.feeds))
+	for _, f := range b.feeds {
+		retentions[f.name] = f.opts.Retention.merge(b.opts.Retention)
+	}
+	b.l.Unlock()
+
+	if lister, ok := b.store.(FeedLister); ok {
+		names, err := lister.Feeds()
+		if err != nil {
+			b.logger.Error("can not list the stored feeds", "error", err)
+		}
+		for _, name := range names {
+			if _, exists := retentions[name]; !exists {
+				retentions[name] = b.opts.Retention
+			}
+		}
+	}
+
+	for name, retention := range retentions {
+		if retention.isZero() {
+			continue
+		}
+
+		events, err := b.store.Read(name, time.Time{}, time.Time{})
+		if err != nil {
+			b.logger.Error("can not read feed", "feed", name, "error", err)
+			continue
+		}
+
+		cutoff := retention.cutoff(events, now)
+		if cutoff.IsZero() {
+			continue
+		}
+		if err := b.store.Truncate(name, cutoff); err != nil {
+			b.logger.Error("can not compact feed", "feed", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package lp
+
+import (
+	"testing"
+	"time"
+)
+
+func retentionEvents(now time.Time, sizes ...int) []*Event {
+	events := make([]*Event, 0, len(sizes))
+	for i, size := range sizes {
+		ts := now.Add(time.Duration(i-len(sizes)) * time.Minute)
+		events = append(events, &Event{seq: uint64(i + 1), feed: "f", ts: ts, size: size})
+	}
+	return events
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Now()
+	// Events published 5, 4, 3, 2 and 1 minutes ago
+	events := retentionEvents(now, 10, 10, 10, 10, 10)
+
+	tests := []struct {
+		name      string
+		retention Retention
+		cutoff    time.Time
+	}{
+		{"no limits", Retention{}, time.Time{}},
+		{"max age", Retention{MaxAge: 150 * time.Second}, now.Add(-150 * time.Second)},
+		{"max count", Retention{MaxCount: 2}, events[3].ts},
+		{"count not reached", Retention{MaxCount: 10}, time.Time{}},
+		{"max bytes", Retention{MaxBytes: 35}, events[2].ts},
+		{"bytes not reached", Retention{MaxBytes: 50}, time.Time{}},
+		{"newest event too big", Retention{MaxBytes: 5}, now},
+		{"strictest limit", Retention{MaxAge: time.Hour, MaxCount: 3, MaxBytes: 25}, events[3].ts},
+	}
+
+	for _, test := range tests {
+		if cutoff := test.retention.cutoff(events, now); !cutoff.Equal(test.cutoff) {
+			t.Errorf("%s: cutoff %s, want %s", test.name, cutoff, test.cutoff)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	store := NewMemoryEventStore()
+	b := NewBroker(Options{
+		Logger:    DiscardLogger,
+		Store:     store,
+		Retention: Retention{MaxCount: 2},
+	})
+	defer b.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	own, _ := b.NewFeedWithOptions("own", FeedOptions{History: true, Retention: Retention{MaxCount: 1}})
+	for i := 0; i < 4; i++ {
+		b.NewEvent(feed, i)
+		b.NewEvent(own, i)
+		time.Sleep(time.Millisecond)
+	}
+
+	// A feed of a previous run, not created in this broker
+	now := time.Now()
+	for i := uint64(1); i <= 3; i++ {
+		store.Append(&Event{seq: 100 + i, feed: "old", ts: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+
+	b.compact(now.Add(time.Second))
+
+	for name, want := range map[string]int{"f": 2, "own": 1, "old": 2} {
+		events, _ := store.Read(name, time.Time{}, time.Time{})
+		if len(events) != want {
+			t.Errorf("%s: %d events, want %d", name, len(events), want)
+		}
+	}
+}
@@ -3,20 +3,80 @@ package lp
 import (
 	"errors"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"sync"
+	"time"
 )
 
-// SDK are the connection parameters
+// ErrSubscriptionClosed is returned when the subscription has been closed
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// ErrQueueOverflow is returned when the subscription has been terminated
+// because its queue overflowed (OverflowTerminate policy). If EventsHandler
+// returns true, the SDK subscribes again and resumes from After.
+var ErrQueueOverflow = errors.New("subscription terminated: queue overflow")
+
+// errSubscriptionNotFound is returned when the server does not know the
+// subscription anymore (eg: expired by the reaper)
+var errSubscriptionNotFound = errors.New("not valid subscriptionID")
+
+// Delays between the subscriptions made again when the server does not know
+// the subscription
+const (
+	sdkMinBackoff = time.Second
+	sdkMaxBackoff = 30 * time.Second
+)
+
+// SDK are the connection parameters.
+// Username and Password are sent with basic auth, if Username is set; Token
+// is sent as bearer token (see SignToken), if set.
+// Logger receives the SDK logs; without it, Debug writes the debug logs on
+// stderr.
+// Feeds can contain feed patterns (eg: orders.*.created or orders.>).
+// MaxQueue and Overflow (one of the OverflowPolicy values) bound the
+// subscription queue on the server.
+// Last and Since start the subscription from the past events of the feeds
+// (the last N events and/or the events published since a time).
+// Linger, MaxEvents and MaxBytes batch the events of each listen response.
+// Filter is a filter expression (see CompileFilter): only the matching
+// events are delivered.
+// Ack enables the at-least-once delivery: the events are acknowledged once
+// EventsHandler returns true, otherwise the server delivers them again.
+// After is the id of the last received event: if set before Connect(), the
+// retained events newer than After are replayed. It is updated with the ids
+// of the received events.
+// When the server shuts down, Connect() subscribes again (to the node the
+// server redirects to, if any) and resumes from After, without errors. The
+// same happens, with an increasing delay, when the server does not know the
+// subscription anymore (eg: expired while the client was not listening).
 type SDK struct {
 	Protocol       string
 	Host           string
 	Port           int
+	Username       string
+	Password       string
+	Token          string
 	Feeds          []string
 	Timeout        int
 	Debug          bool
+	Logger         Logger
+	MaxQueue       int
+	Overflow       OverflowPolicy
+	Last           int
+	Since          time.Time
+	Linger         time.Duration
+	MaxEvents      int
+	MaxBytes       int
+	Ack            bool
+	Filter         string
+	After          uint64
+	l              sync.Mutex
 	subscriptionID string
+	closing        bool
 }
 
 // LongPollClient is the interface that should be passed to an SDK client
@@ -24,88 +84,367 @@ type LongPollClient interface {
 	EventsHandler([]EventData, error) bool
 }
 
+// DroppedHandler can be implemented by a LongPollClient to be told how many
+// events have been dropped from the subscription queue on the server
+type DroppedHandler interface {
+	EventsDropped(dropped int)
+}
+
 // Connect main method to interact with SDK
 func (sdk *SDK) Connect(lpc LongPollClient) error {
-	var events []EventData
+	resume := false
+	backoff := sdkMinBackoff
+	for {
+		subscribed := time.Now()
+		err := sdk.connect(lpc, resume)
+		resume = true
 
-	protocol := sdk.Protocol
-	if protocol == "" {
-		protocol = "http"
-	}
+		// The subscription has been terminated, the client wants more
+		if err == ErrQueueOverflow {
+			sdk.logger().Info("queue overflow, subscribing again")
+			continue
+		}
 
-	host := sdk.Host
-	if host == "" {
-		host = "localhost"
-	}
+		// The subscription expired or has been closed by the server. The
+		// delay grows while the new subscriptions are lost quickly.
+		if err == errSubscriptionNotFound {
+			if time.Since(subscribed) > sdkMaxBackoff {
+				backoff = sdkMinBackoff
+			}
+			sdk.logger().Info("subscription not found, subscribing again", "retryAfter", backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > sdkMaxBackoff {
+				backoff = sdkMaxBackoff
+			}
+			continue
+		}
 
-	port := sdk.Port
-	if port == 0 {
-		port = 8080
+		shutdown, ok := err.(serverShutdownError)
+		if !ok {
+			return err
+		}
+
+		// The server is shutting down: reconnect to the node it redirects
+		// to, or to the same server after a while
+		logger := sdk.logger()
+		if shutdown.redirect != "" {
+			logger.Info("server shutting down, reconnecting", "redirect", shutdown.redirect)
+			if err := sdk.setServerURL(shutdown.redirect); err != nil {
+				logger.Warn("invalid redirect", "redirect", shutdown.redirect, "error", err)
+				time.Sleep(shutdown.retryAfter)
+			}
+		} else {
+			logger.Info("server shutting down, reconnecting", "retryAfter", shutdown.retryAfter)
+			time.Sleep(shutdown.retryAfter)
+		}
 	}
+}
+
+// connect subscribes and listens until the client stops, the subscription
+// overflows (ErrQueueOverflow) or is not found (errSubscriptionNotFound) or
+// the server shuts down (serverShutdownError). Resuming, the history is not requested
+// again: the events newer than After are replayed instead.
+func (sdk *SDK) connect(lpc LongPollClient, resume bool) error {
+	var events []EventData
 
 	timeout := sdk.Timeout
 	if timeout == 0 {
 		timeout = 30
 	}
 
-	// PRepare subscription feed list
-	feeds := ""
-	for _, feedName := range sdk.Feeds {
-		feeds += "feed=" + feedName + "&"
-	}
-
-	serverURL := getServerURL(protocol, host, port)
+	serverURL := sdk.serverURL()
 
 	// 1. Subscribe to one or more feeds
-	subscriptionRequestURL := serverURL + "/subscribe?" + feeds
-	sdk.log("-> %s\n", subscriptionRequestURL)
+	subscriptionRequestURL := serverURL + "/subscribe?" + feedsQuery(sdk.Feeds)
+	if sdk.MaxQueue > 0 {
+		subscriptionRequestURL += "maxqueue=" + strconv.Itoa(sdk.MaxQueue) + "&"
+	}
+	if sdk.Overflow != "" {
+		subscriptionRequestURL += "overflow=" + url.QueryEscape(string(sdk.Overflow)) + "&"
+	}
+	if sdk.Last > 0 && !resume {
+		subscriptionRequestURL += "last=" + strconv.Itoa(sdk.Last) + "&"
+	}
+	if !sdk.Since.IsZero() && !resume {
+		subscriptionRequestURL += "since=" + url.QueryEscape(sdk.Since.Format(time.RFC3339Nano)) + "&"
+	}
+	if sdk.Ack {
+		subscriptionRequestURL += "ack=true&"
+	}
+	if sdk.Filter != "" {
+		subscriptionRequestURL += "filter=" + url.QueryEscape(sdk.Filter) + "&"
+	}
+	logger := sdk.logger()
+	logger.Debug("subscribing", "url", subscriptionRequestURL)
 
-	subscriptionID, err := getSubscriptionID(subscriptionRequestURL)
+	subscriptionID, err := sdk.getSubscriptionID(subscriptionRequestURL)
+	sdk.l.Lock()
 	sdk.subscriptionID = subscriptionID
-	sdk.log("<- SubscriptionID=%s\n", subscriptionID)
+	sdk.closing = false
+	sdk.l.Unlock()
 
+	if _, ok := err.(serverShutdownError); ok {
+		return err
+	}
 	if err != nil {
+		logger.Warn("can not subscribe", "error", err)
 		if lpc.EventsHandler(events, err) == false {
 			return err
 		}
 	}
 
+	logger.Debug("subscribed", "subscriptionID", subscriptionID)
+
 	// 2. Listen and send the events to the callback. Stop when the callback
 	//    returns false
-	listenRequestURL := serverURL + "/listen?subscriptionID=" + sdk.subscriptionID + "&timeout=" + strconv.Itoa(timeout)
+	listenRequestURL := serverURL + "/listen?subscriptionID=" + url.QueryEscape(subscriptionID) + "&timeout=" + strconv.Itoa(timeout)
+	if sdk.Linger > 0 {
+		listenRequestURL += "&linger=" + strconv.FormatInt(int64(sdk.Linger/time.Millisecond), 10)
+	}
+	if sdk.MaxEvents > 0 {
+		listenRequestURL += "&maxevents=" + strconv.Itoa(sdk.MaxEvents)
+	}
+	if sdk.MaxBytes > 0 {
+		listenRequestURL += "&maxbytes=" + strconv.Itoa(sdk.MaxBytes)
+	}
+
+	// The first request replays the events newer than the cursor
+	requestURL := listenRequestURL
+	if sdk.After > 0 {
+		requestURL += "&after=" + strconv.FormatUint(sdk.After, 10)
+	}
 
 	for true {
-		sdk.log("-> %s\n", listenRequestURL)
+		logger.Debug("listening", "url", requestURL)
 
-		events, timeout, err := getEvents(listenRequestURL)
+		events, dropped, timeout, err := sdk.getEvents(requestURL)
 		if timeout {
-			sdk.log("<- Timeout, reconnect...\n")
+			logger.Debug("listen timeout, reconnecting", "subscriptionID", subscriptionID)
+			continue
+		}
+
+		// Too many requests, wait before the next one
+		if limited, ok := err.(rateLimitedError); ok {
+			logger.Warn("rate limited", "subscriptionID", subscriptionID, "retryAfter", limited.retryAfter)
+			time.Sleep(limited.retryAfter)
 			continue
 		}
 
-		sdk.log("<- %+v\n", events)
+		// The server is shutting down, Connect() reconnects
+		if _, ok := err.(serverShutdownError); ok {
+			logger.Debug("server shutting down", "subscriptionID", subscriptionID)
+			return err
+		}
+
+		// The subscription has been closed by SDK.Close(). The request may
+		// also fail as not valid subscription, if the subscription was closed
+		// between two requests.
+		sdk.l.Lock()
+		closing := sdk.closing
+		sdk.l.Unlock()
+		if closing {
+			logger.Debug("subscription closed", "subscriptionID", subscriptionID)
+			break
+		}
+
+		// The server does not know the subscription, Connect() subscribes
+		// again
+		if err == errSubscriptionNotFound {
+			logger.Debug("subscription not found", "subscriptionID", subscriptionID)
+			return err
+		}
+
+		if err != nil {
+			logger.Warn("listen failed", "subscriptionID", subscriptionID, "error", err)
+		} else {
+			logger.Debug("events received", "subscriptionID", subscriptionID, "events", len(events), "dropped", dropped)
+		}
+
+		if dh, ok := lpc.(DroppedHandler); ok && dropped > 0 {
+			dh.EventsDropped(dropped)
+		}
+
+		requestURL = listenRequestURL
+		for _, e := range events {
+			if e.ID > sdk.After {
+				sdk.After = e.ID
+			}
+		}
 
 		if lpc.EventsHandler(events, err) == false {
-			sdk.log("STOP\n")
+			logger.Debug("stopped by the client", "subscriptionID", subscriptionID)
 			break
 		}
+
+		// The server closed the subscription, Connect() subscribes again
+		if err == ErrQueueOverflow {
+			return err
+		}
+
+		// The events have been handled, acknowledge them with the next
+		// request
+		if sdk.Ack {
+			for _, e := range events {
+				if e.ID > 0 {
+					requestURL += "&ack=" + strconv.FormatUint(e.ID, 10)
+				}
+			}
+		}
 	}
 
 	return nil
 }
 
-func (sdk *SDK) log(format string, i ...interface{}) {
-	if sdk.Debug {
-		if len(i) > 0 {
-			log.Printf(format, i)
-		} else {
-			log.Printf(format)
+// AddFeeds subscribes the current subscription to more feeds. Feeds is
+// updated with the subscribed feeds and patterns, so a new subscription
+// (eg: after a reconnection) gets the same feeds.
+func (sdk *SDK) AddFeeds(feeds ...string) error {
+	return sdk.updateFeeds("/subscription/add", feeds)
+}
+
+// RemoveFeeds unsubscribes the current subscription from some feeds
+func (sdk *SDK) RemoveFeeds(feeds ...string) error {
+	return sdk.updateFeeds("/subscription/remove", feeds)
+}
+
+// Close closes the current subscription. A running Connect() returns
+// without errors.
+func (sdk *SDK) Close() error {
+	sdk.l.Lock()
+	subscriptionID := sdk.subscriptionID
+	sdk.closing = true
+	sdk.l.Unlock()
+
+	if subscriptionID == "" {
+		return errors.New("not subscribed")
+	}
+
+	requestURL := sdk.serverURL() + "/subscription/close?subscriptionID=" + url.QueryEscape(subscriptionID)
+	sdk.logger().Debug("request", "url", requestURL)
+
+	var resp struct {
+		Error   bool
+		Message string
+	}
+	if err := sdk.getJSON(requestURL, &resp); err != nil {
+		return err
+	}
+	if resp.Error {
+		return errors.New(resp.Message)
+	}
+	return nil
+}
+
+func (sdk *SDK) updateFeeds(path string, feeds []string) error {
+	sdk.l.Lock()
+	subscriptionID := sdk.subscriptionID
+	sdk.l.Unlock()
+
+	if subscriptionID == "" {
+		return errors.New("not subscribed")
+	}
+
+	requestURL := sdk.serverURL() + path + "?subscriptionID=" + url.QueryEscape(subscriptionID) + "&" + feedsQuery(feeds)
+	sdk.logger().Debug("request", "url", requestURL)
+
+	var resp struct {
+		Error    bool
+		Message  string
+		Feeds    []string
+		Patterns []string
+	}
+	if err := sdk.getJSON(requestURL, &resp); err != nil {
+		return err
+	}
+	if resp.Error {
+		return errors.New(resp.Message)
+	}
+
+	sdk.l.Lock()
+	sdk.Feeds = append(resp.Feeds, resp.Patterns...)
+	sdk.l.Unlock()
+	return nil
+}
+
+// serverURL returns the server URL, using default values for the missing
+// connection parameters
+func (sdk *SDK) serverURL() string {
+	protocol := sdk.Protocol
+	if protocol == "" {
+		protocol = "http"
+	}
+
+	host := sdk.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	port := sdk.Port
+	if port == 0 {
+		port = 8080
+	}
+
+	return getServerURL(protocol, host, port)
+}
+
+// setServerURL sets the connection parameters from a server URL
+func (sdk *SDK) setServerURL(serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Hostname() == "" {
+		return errors.New("not valid server URL " + serverURL)
+	}
+
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return err
 		}
 	}
+
+	sdk.Protocol = u.Scheme
+	sdk.Host = u.Hostname()
+	sdk.Port = port
+	return nil
+}
+
+// sdkDebugLogger is the logger used when Debug is set
+var sdkDebugLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+// logger returns the SDK Logger. Without a Logger, the debug messages are
+// written on stderr if Debug is set, otherwise nothing is logged.
+func (sdk *SDK) logger() Logger {
+	if sdk.Logger != nil {
+		return sdk.Logger
+	}
+	if sdk.Debug {
+		return sdkDebugLogger
+	}
+	return DiscardLogger
+}
+
+// get sends a GET request, with the credentials if any
+func (sdk *SDK) get(requestURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sdk.Username != "" {
+		req.SetBasicAuth(sdk.Username, sdk.Password)
+	}
+	if sdk.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sdk.Token)
+	}
+	return http.DefaultClient.Do(req)
 }
 
-func getSubscriptionID(subscriptionRequestURL string) (string, error) {
-	httpResponse, err := http.Get(subscriptionRequestURL)
+func (sdk *SDK) getSubscriptionID(subscriptionRequestURL string) (string, error) {
+	httpResponse, err := sdk.get(subscriptionRequestURL)
 	if err != nil {
 		return "", err
 	}
@@ -115,47 +454,149 @@ func getSubscriptionID(subscriptionRequestURL string) (string, error) {
 		return "", err
 	}
 
-	type decodedResponse struct{ SubscriptionID string }
+	type decodedResponse struct {
+		Error          bool
+		Message        string
+		Redirect       string
+		SubscriptionID string
+	}
 	var sr decodedResponse
 	err = fromJSON(body, &sr)
 	if err != nil {
 		return "", err
 	}
+	if sr.Error && sr.Message == ShutdownMessage {
+		return "", newServerShutdownError(httpResponse, sr.Redirect)
+	}
+	if sr.Error {
+		return "", errors.New(sr.Message)
+	}
 	if sr.SubscriptionID == "" {
 		return "", errors.New("server did not return SubscriptionID")
 	}
 	return sr.SubscriptionID, nil
 }
 
-func getEvents(listenRequestURL string) (events []EventData, timeout bool, err error) {
-	httpResponse, err := http.Get(listenRequestURL)
+func (sdk *SDK) getEvents(listenRequestURL string) (events []EventData, dropped int, timeout bool, err error) {
+	httpResponse, err := sdk.get(listenRequestURL)
 	if err != nil {
-		return events, false, err
+		return events, 0, false, err
 	}
 
 	body, err := ioutil.ReadAll(httpResponse.Body)
 	if err != nil {
-		return events, false, err
+		return events, 0, false, err
+	}
+
+	if httpResponse.StatusCode == http.StatusTooManyRequests {
+		return events, 0, false, newRateLimitedError(httpResponse)
 	}
 
 	type decodedResponse struct {
-		Error   bool
-		Message string
-		Events  []EventData
+		Error    bool
+		Message  string
+		Redirect string
+		Events   []EventData
+		Dropped  int
 	}
 	var resp decodedResponse
 	err = fromJSON(body, &resp)
 	if err != nil {
-		return events, false, err
+		return events, 0, false, err
 	}
 
 	// Return timeout
 	if resp.Error == true && resp.Message == "timeout" {
-		return events, true, nil
+		return events, 0, true, nil
+	}
+
+	// The subscription does not exist anymore
+	if resp.Error == true && resp.Message == "subscription closed" {
+		return events, 0, false, ErrSubscriptionClosed
+	}
+
+	// The subscription queue overflowed
+	if resp.Error == true && resp.Message == ErrQueueOverflow.Error() {
+		return events, 0, false, ErrQueueOverflow
+	}
+
+	// The server is shutting down
+	if resp.Error == true && resp.Message == ShutdownMessage {
+		return events, 0, false, newServerShutdownError(httpResponse, resp.Redirect)
+	}
+
+	// The subscription expired or has been closed
+	if resp.Error == true && httpResponse.StatusCode == http.StatusForbidden && resp.Message == errSubscriptionNotFound.Error() {
+		return events, 0, false, errSubscriptionNotFound
+	}
+
+	// The request has been refused
+	if resp.Error == true {
+		return events, 0, false, errors.New(resp.Message)
 	}
 
 	// Extract events
-	return resp.Events, false, nil
+	return resp.Events, resp.Dropped, false, nil
+}
+
+// rateLimitedError is returned when the server answers 429
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func newRateLimitedError(httpResponse *http.Response) rateLimitedError {
+	return rateLimitedError{retryAfter(httpResponse)}
+}
+
+// retryAfter returns the Retry-After header of a response (at least one
+// second)
+func retryAfter(httpResponse *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(httpResponse.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (e rateLimitedError) Error() string {
+	return "rate limit exceeded, retry after " + e.retryAfter.String()
+}
+
+// serverShutdownError is returned when the server is shutting down.
+// Redirect is the URL of the node to reconnect to, if any.
+type serverShutdownError struct {
+	retryAfter time.Duration
+	redirect   string
+}
+
+func newServerShutdownError(httpResponse *http.Response, redirect string) serverShutdownError {
+	return serverShutdownError{retryAfter(httpResponse), redirect}
+}
+
+func (e serverShutdownError) Error() string {
+	return ShutdownMessage
+}
+
+func (sdk *SDK) getJSON(requestURL string, object interface{}) error {
+	httpResponse, err := sdk.get(requestURL)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	return fromJSON(body, object)
+}
+
+func feedsQuery(feeds []string) string {
+	query := ""
+	for _, feedName := range feeds {
+		query += "feed=" + url.QueryEscape(feedName) + "&"
+	}
+	return query
 }
 
 func getServerURL(protocol string, host string, port int) string {
@@ -0,0 +1,285 @@
+package lp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ShutdownMessage is the message of the response sent to the clients when
+// the broker shuts down
+const ShutdownMessage = "server shutting down, reconnect"
+
+// ShutdownResponse is sent (503) to the listeners when the broker shuts down.
+// Redirect, if set, is the URL of the node the clients should reconnect to.
+type ShutdownResponse struct {
+	Error     bool
+	ErrorCode int
+	Message   string
+	Redirect  string `json:",omitempty"`
+}
+
+// Shutdown drains the broker: new subscriptions are refused, every waiting
+// listener is woken with a ShutdownResponse and the handlers served by
+// Handler() are waited for. If the store is a SubscriptionSaver, the
+// subscriptions are saved with the id of the last event delivered: the next
+// broker using the store restores them, queueing again from the store the
+// events not delivered (or not acknowledged), so the clients keep their
+// subscriptionID. Then the broker and its store are closed. If the context
+// expires first, the broker is closed anyway and the context error is
+// returned.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.l.Lock()
+	b.shuttingDown = true
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.l.Unlock()
+
+	b.logger.Info("shutting down", "subscriptions", len(subscriptions))
+
+	for _, s := range subscriptions {
+		s.shutdown()
+	}
+
+	err := b.handlers.wait(ctx)
+	if saveErr := b.saveSubscriptions(); err == nil {
+		err = saveErr
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isShuttingDown returns true once Shutdown has been called
+func (b *Broker) isShuttingDown() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return b.shuttingDown
+}
+
+// shutdown wakes the listener with the shutdown state. The following
+// listeners are woken immediately.
+func (s *Subscription) shutdown() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.draining = true
+	s.terminate(stateShutdown)
+}
+
+// saveSubscriptions saves the subscriptions in the store, if it is a
+// SubscriptionSaver. The saved subscriptions not restored yet are saved
+// again.
+func (b *Broker) saveSubscriptions() error {
+	saver, ok := b.store.(SubscriptionSaver)
+	if !ok {
+		return nil
+	}
+
+	// The events published from now on are not delivered
+	b.publishLock.Lock()
+	last := b.seq
+	b.publishLock.Unlock()
+
+	b.l.Lock()
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	states := make([]SubscriptionState, 0, len(subscriptions)+len(b.restored))
+	for _, state := range b.restored {
+		states = append(states, state)
+	}
+	b.l.Unlock()
+
+	for _, s := range subscriptions {
+		states = append(states, s.state(last))
+	}
+
+	if err := saver.SaveSubscriptions(states); err != nil {
+		b.logger.Error("can not save the subscriptions", "error", err)
+		return err
+	}
+	b.logger.Info("subscriptions saved", "subscriptions", len(states))
+	return nil
+}
+
+// state returns the subscription state to save. After is the id of the
+// last published event, or the one before the oldest event still queued or
+// waiting for an ack.
+func (s *Subscription) state(last uint64) SubscriptionState {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	state := SubscriptionState{
+		ID:         string(s.id),
+		Patterns:   make([]string, 0, len(s.patterns)),
+		MaxQueue:   s.opts.MaxQueue,
+		Overflow:   s.opts.Overflow,
+		Ack:        s.opts.Ack,
+		AckTimeout: s.opts.AckTimeout,
+		Owner:      s.opts.Owner,
+		After:      last,
+	}
+	for id := range s.named {
+		state.Feeds = append(state.Feeds, s.feeds[id].name)
+	}
+	for pattern := range s.patterns {
+		state.Patterns = append(state.Patterns, pattern)
+	}
+	if s.opts.Filter != nil {
+		state.Filter = s.opts.Filter.expression
+	}
+
+	for _, e := range s.events {
+		if e.seq > 0 && e.seq <= state.After {
+			state.After = e.seq - 1
+		}
+	}
+	for seq := range s.inflight {
+		if seq <= state.After {
+			state.After = seq - 1
+		}
+	}
+	return state
+}
+
+// loadSubscriptions reads the subscriptions saved by the previous broker,
+// if the store is a SubscriptionSaver. They are restored on first use.
+func (b *Broker) loadSubscriptions() {
+	saver, ok := b.store.(SubscriptionSaver)
+	if !ok {
+		return
+	}
+	states, err := saver.LoadSubscriptions()
+	if err != nil {
+		b.logger.Error("can not load the saved subscriptions", "error", err)
+		return
+	}
+
+	b.restored = make(map[uuid]SubscriptionState, len(states))
+	for _, state := range states {
+		b.restored[uuid(state.ID)] = state
+	}
+	b.restoredAt = time.Now()
+}
+
+// restoreSubscription creates again a saved subscription, queueing the
+// events newer than its cursor. The feeds that do not exist anymore are
+// skipped.
+func (b *Broker) restoreSubscription(state SubscriptionState) (*Subscription, error) {
+	opts := SubscriptionOptions{
+		MaxQueue:   state.MaxQueue,
+		Overflow:   state.Overflow,
+		Ack:        state.Ack,
+		AckTimeout: state.AckTimeout,
+		Owner:      state.Owner,
+	}
+	if state.Filter != "" {
+		filter, err := CompileFilter(state.Filter)
+		if err != nil {
+			return nil, err
+		}
+		opts.Filter = filter
+	}
+
+	s := b.newSubscription(uuid(state.ID), opts)
+	for _, feedName := range state.Feeds {
+		feed, err := b.GetFeedFromName(feedName)
+		if err != nil {
+			b.logger.Debug("feed of a restored subscription not found", "subscriptionID", state.ID, "feed", feedName)
+			continue
+		}
+		s.Subscribe(feed)
+	}
+	for _, pattern := range state.Patterns {
+		b.SubscribePattern(s, pattern)
+	}
+	if err := b.resume(s, state.After); err != nil {
+		b.logger.Error("can not queue the events of a restored subscription", "subscriptionID", state.ID, "error", err)
+	}
+
+	b.logger.Debug("subscription restored", "subscriptionID", state.ID, "after", state.After)
+	return s, nil
+}
+
+// shutdownResponse returns the response sent to the clients during the
+// shutdown
+func (b *Broker) shutdownResponse() ShutdownResponse {
+	return ShutdownResponse{true, 503, ShutdownMessage, b.opts.ShutdownRedirect}
+}
+
+// sendShutdown answers a request received during the shutdown
+func (b *Broker) sendShutdown(w http.ResponseWriter, r *http.Request) {
+	b.logger.Debug("request refused, shutting down", requestFields(r)...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(503)
+	json, err := toJSON(b.shutdownResponse())
+	if err != nil {
+		SendError(w, 500, err.Error())
+		return
+	}
+	w.Write([]byte(json))
+}
+
+// handlerGroup counts the handlers in flight
+type handlerGroup struct {
+	l      sync.Mutex
+	active int
+	idle   chan struct{}
+}
+
+// track wraps a handler, so Shutdown waits for it
+func (g *handlerGroup) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.enter()
+		defer g.leave()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (g *handlerGroup) enter() {
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	g.active++
+}
+
+func (g *handlerGroup) leave() {
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	g.active--
+	if g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// wait returns when no handler is in flight, or when the context expires
+func (g *handlerGroup) wait(ctx context.Context) error {
+	g.l.Lock()
+	if g.active == 0 {
+		g.l.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.l.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,144 @@
+package lp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdownWakesListeners(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger, ShutdownRedirect: "http://node2:8080"})
+	srv := httptest.NewServer(b.Handler())
+	defer srv.Close()
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+
+	type result struct {
+		code int
+		body ShutdownResponse
+	}
+	listen := func() result {
+		resp, err := srv.Client().Get(srv.URL + "/listen?subscriptionID=" + s.ID() + "&timeout=10")
+		if err != nil {
+			return result{}
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var r result
+		r.code = resp.StatusCode
+		json.Unmarshal(body, &r.body)
+		return r
+	}
+
+	woken := make(chan result, 1)
+	go func() { woken <- listen() }()
+	for !listening(s) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-woken:
+		if r.code != 503 || r.body.Message != ShutdownMessage || r.body.Redirect != "http://node2:8080" {
+			t.Fatalf("listener woken with %d %+v", r.code, r.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener not woken")
+	}
+
+	// The later requests are answered immediately
+	if r := listen(); r.code != 503 {
+		t.Fatalf("listen after the shutdown: %d", r.code)
+	}
+	resp, err := srv.Client().Get(srv.URL + "/subscribe?feed=f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("subscribe after the shutdown: %d", resp.StatusCode)
+	}
+}
+
+// listening returns true if a listener is attached to the subscription
+func listening(s *Subscription) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.listener != nil
+}
+
+func TestShutdownSavesSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBroker(Options{Logger: DiscardLogger, Store: store})
+
+	orders, _ := b.NewFeed("orders.eu")
+	filter, _ := CompileFilter("payload > 0")
+	s := b.NewSubscriptionWithOptions(SubscriptionOptions{MaxQueue: 10, Filter: filter})
+	b.SubscribePattern(s, "orders.*")
+	for i := 0; i < 4; i++ {
+		b.NewEvent(orders, i)
+	}
+	delivered, _ := s.takeEvents(1, 0)
+	if delivered[0].seq != 2 {
+		t.Fatalf("delivered %d, want 2", delivered[0].seq)
+	}
+
+	acked := b.NewSubscriptionWithOptions(SubscriptionOptions{Ack: true})
+	acked.Subscribe(orders)
+	b.NewEvent(orders, 4)
+	b.NewEvent(orders, 5)
+	acked.takeEvents(0, 0)
+	acked.Ack(6)
+
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next broker restores the subscriptions on first use, with the
+	// events not delivered or not acknowledged
+	store, err = NewFileEventStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = NewBroker(Options{Logger: DiscardLogger, Store: store})
+	defer b.Close()
+	b.NewFeed("orders.eu")
+
+	restored, err := b.GetSubscription(s.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patterns := restored.Patterns(); len(patterns) != 1 || patterns[0] != "orders.*" {
+		t.Fatalf("patterns %v, want [orders.*]", patterns)
+	}
+	if seqs := eventSeqs(restored.GetEvents()); !equalSeqs(seqs, []uint64{3, 4, 5, 6}) {
+		t.Fatalf("queued %v, want [3 4 5 6]", seqs)
+	}
+
+	restored, err = b.GetSubscription(acked.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.opts.Ack {
+		t.Fatal("ack option not restored")
+	}
+	if seqs := eventSeqs(restored.GetEvents()); !equalSeqs(seqs, []uint64{5, 6}) {
+		t.Fatalf("queued %v, want [5 6]", seqs)
+	}
+
+	if _, err := b.GetSubscription("unknown"); err == nil {
+		t.Fatal("unknown subscription found")
+	}
+}
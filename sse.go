@@ -0,0 +1,147 @@
+package lp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SSEHandler streams the events of a subscription as Server-Sent Events
+// (text/event-stream), so browsers can use EventSource. The response stays
+// open until the client goes away: each event is written as a frame with the
+// event id as SSE id, and a comment is sent every Options.Heartbeat. The
+// Last-Event-ID header (or the after parameter) replays the retained events
+// newer than it. Each batch of events counts as a listen with events, and
+// the end of the stream as a listen with its outcome.
+func (b *Broker) SSEHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		b.sendError(w, r, 500, "streaming not supported")
+		return
+	}
+
+	subscription, err := b.GetSubscription(extractSubscription(r))
+	if err != nil {
+		b.sendError(w, r, 403, "not valid subscriptionID")
+		return
+	}
+
+	if !b.checkOwner(w, r, subscription) {
+		return
+	}
+
+	if !b.authorize(w, r, ActionListen, subscriptionFeeds(subscription)) {
+		return
+	}
+
+	if ok, wait := b.limitListen(r, subscription); !ok {
+		b.sendRateLimited(w, r, wait)
+		return
+	}
+
+	// Replay the retained events newer than the client cursor
+	after, resume := extractAfter(r)
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			after, resume = id, true
+		}
+	}
+	if resume {
+		if err := b.resume(subscription, after); err != nil {
+			b.sendError(w, r, 500, "can not read events")
+			return
+		}
+	}
+
+	// Attach this connection as listener, for the whole stream
+	signal := subscription.listen()
+	defer subscription.release(signal)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(b.opts.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+
+		case st := <-signal:
+			switch st {
+			case stateReady:
+				events, dropped := subscription.takeEvents(0, 0)
+				if dropped > 0 {
+					if err := writeSSE(w, "dropped", 0, struct{ Dropped int }{dropped}); err != nil {
+						return
+					}
+				}
+				for _, e := range events {
+					if err := writeSSE(w, "", e.seq, e.data()); err != nil {
+						return
+					}
+				}
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					return
+				}
+				b.metrics.listen(listenEvents)
+				b.hooks.delivered(subscription, events)
+			case stateAbort:
+				b.metrics.listen(listenAbort)
+				writeSSE(w, "error", 0, ErrorResponse{true, 500, "ABORTED"})
+				flusher.Flush()
+				return
+			case stateClosed:
+				b.metrics.listen(listenClosed)
+				writeSSE(w, "error", 0, ErrorResponse{true, 410, "subscription closed"})
+				flusher.Flush()
+				return
+			case stateOverflow:
+				b.metrics.listen(listenOverflow)
+				b.CloseSubscription(subscription)
+				writeSSE(w, "error", 0, ErrorResponse{true, 410, "subscription terminated: queue overflow"})
+				flusher.Flush()
+				return
+			case stateShutdown:
+				b.metrics.listen(listenShutdown)
+				writeSSE(w, "shutdown", 0, b.shutdownResponse())
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		// The client went away
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes a single SSE frame. Empty event and zero id are omitted.
+// It returns the write error, if the client went away.
+func writeSSE(w http.ResponseWriter, event string, id uint64, object interface{}) error {
+	json, err := toJSON(object)
+	if err != nil {
+		json = `{"Error":true,"ErrorCode":500,"Message":"can not encode event"}`
+		event = "error"
+	}
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", json)
+	return err
+}
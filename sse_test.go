@@ -0,0 +1,66 @@
+package lp
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEHandler(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	srv := httptest.NewServer(b.Handler())
+	defer srv.Close()
+
+	feed, _ := b.NewFeedWithOptions("f", FeedOptions{History: true})
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	b.NewEvent(feed, "a")
+	b.NewEvent(feed, "b")
+	s.GetEvents()
+
+	// The events after Last-Event-ID are replayed
+	req, _ := http.NewRequest("GET", srv.URL+"/sse?subscriptionID="+s.ID(), nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	frames := make([]string, 0)
+	frame := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			frame += line + "\n"
+			continue
+		}
+		frames = append(frames, frame)
+		if strings.HasPrefix(frame, "id: 2\n") {
+			b.CloseSubscription(s)
+		}
+		frame = ""
+	}
+
+	if len(frames) != 2 || !strings.Contains(frames[0], `"Payload":"b"`) || !strings.HasPrefix(frames[1], "event: error\n") {
+		t.Fatalf("frames %q, want the replayed event and the closing error", frames)
+	}
+
+	var out bytes.Buffer
+	b.WriteMetrics(&out)
+	for _, want := range []string{
+		`lp_listen_requests_total{outcome="events"} 1`,
+		`lp_listen_requests_total{outcome="closed"} 1`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+}
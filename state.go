@@ -8,6 +8,9 @@ const (
 	stateTimeout
 	stateOk
 	stateReady
+	stateClosed
+	stateOverflow
+	stateShutdown
 )
 
 func (s state) String() string {
@@ -22,6 +25,12 @@ func (s state) String() string {
 		return "Sent event(s)"
 	case 5:
 		return "Handler can be destroyed"
+	case 6:
+		return "Subscription closed"
+	case 7:
+		return "Subscription terminated due queue overflow"
+	case 8:
+		return "Server shutting down"
 	}
 	return "Unknown"
 }
@@ -0,0 +1,170 @@
+package lp
+
+import (
+	"sync"
+	"time"
+)
+
+// EventStore is the interface an event storage must implement
+type EventStore interface {
+	// Append adds an event at the end of the store
+	Append(ev *Event) error
+	// Read returns, in append order, the events of a feed with a timestamp
+	// in [from, to). A zero from or to means no limit.
+	Read(feed string, from time.Time, to time.Time) ([]*Event, error)
+	// Truncate removes the events of a feed older than before
+	Truncate(feed string, before time.Time) error
+	// LastID returns the highest event id ever appended, so the broker can
+	// keep numbering the events after a restart
+	LastID() (uint64, error)
+	// Close releases the resources used by the store
+	Close() error
+}
+
+// FeedLister can be implemented by an EventStore to list the feeds it holds
+// events of, so the retention policies apply also to the feeds that have not
+// been created again after a restart
+type FeedLister interface {
+	Feeds() ([]string, error)
+}
+
+// SubscriptionSaver can be implemented by an EventStore to keep the
+// subscriptions saved by Broker.Shutdown, so the next broker using the store
+// restores them
+type SubscriptionSaver interface {
+	// SaveSubscriptions replaces the saved subscriptions
+	SaveSubscriptions(states []SubscriptionState) error
+	// LoadSubscriptions returns the saved subscriptions
+	LoadSubscriptions() ([]SubscriptionState, error)
+}
+
+// SubscriptionState is a saved subscription. After is the id before the
+// oldest event not delivered (or not acknowledged) yet: the events newer than
+// After are queued again from the store when the subscription is restored,
+// so some of them may be delivered twice.
+type SubscriptionState struct {
+	ID         string
+	Feeds      []string       `json:",omitempty"`
+	Patterns   []string       `json:",omitempty"`
+	MaxQueue   int            `json:",omitempty"`
+	Overflow   OverflowPolicy `json:",omitempty"`
+	Ack        bool           `json:",omitempty"`
+	AckTimeout time.Duration  `json:",omitempty"`
+	Filter     string         `json:",omitempty"`
+	Owner      string         `json:",omitempty"`
+	After      uint64
+}
+
+// memoryEventStore is an EventStore that keeps the events in memory
+type memoryEventStore struct {
+	l             sync.Mutex
+	feeds         map[string][]*Event
+	lastID        uint64
+	subscriptions []SubscriptionState
+}
+
+// NewMemoryEventStore returns an EventStore that keeps the events in memory.
+// It is the default broker store.
+func NewMemoryEventStore() EventStore {
+	ms := new(memoryEventStore)
+	ms.feeds = make(map[string][]*Event)
+	return ms
+}
+
+// Append adds an event to the store
+// It is thread safe
+func (ms *memoryEventStore) Append(ev *Event) error {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	ms.feeds[ev.feed] = append(ms.feeds[ev.feed], ev)
+	if ev.seq > ms.lastID {
+		ms.lastID = ev.seq
+	}
+	return nil
+}
+
+// Read returns the events of a feed in a time range
+func (ms *memoryEventStore) Read(feed string, from time.Time, to time.Time) ([]*Event, error) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	return filterEvents(ms.feeds[feed], from, to), nil
+}
+
+// Truncate removes the events of a feed older than before
+func (ms *memoryEventStore) Truncate(feed string, before time.Time) error {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	list := ms.feeds[feed]
+	i := 0
+	for i < len(list) && list[i].ts.Before(before) {
+		i++
+	}
+	if i == len(list) {
+		delete(ms.feeds, feed)
+		return nil
+	}
+	// Copy the remaining events, so the old backing array can be freed
+	ms.feeds[feed] = append([]*Event(nil), list[i:]...)
+	return nil
+}
+
+// LastID returns the highest event id ever appended
+func (ms *memoryEventStore) LastID() (uint64, error) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	return ms.lastID, nil
+}
+
+// Feeds returns the names of the feeds with events
+func (ms *memoryEventStore) Feeds() ([]string, error) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	names := make([]string, 0, len(ms.feeds))
+	for name := range ms.feeds {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SaveSubscriptions keeps the subscriptions in memory, for the next broker
+// using the store in the same process
+func (ms *memoryEventStore) SaveSubscriptions(states []SubscriptionState) error {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	ms.subscriptions = append([]SubscriptionState(nil), states...)
+	return nil
+}
+
+// LoadSubscriptions returns the saved subscriptions
+func (ms *memoryEventStore) LoadSubscriptions() ([]SubscriptionState, error) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+
+	return append([]SubscriptionState(nil), ms.subscriptions...), nil
+}
+
+// Close does nothing for the memory store
+func (ms *memoryEventStore) Close() error {
+	return nil
+}
+
+// filterEvents returns the events with a timestamp in [from, to)
+func filterEvents(list []*Event, from time.Time, to time.Time) []*Event {
+	events := make([]*Event, 0)
+	for _, ev := range list {
+		if !from.IsZero() && ev.ts.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !ev.ts.Before(to) {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
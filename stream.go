@@ -0,0 +1,102 @@
+package lp
+
+import (
+	"net/http"
+	"time"
+)
+
+// streamNDJSON keeps the listen response open until the timeout, writing
+// each event as a JSON line (EventData) as soon as it is queued. Dropped
+// events are reported with a {"Dropped": n} line. The stream ends with an
+// ErrorResponse line if the listener is aborted or the subscription closed,
+// or with a ShutdownResponse line if the broker shuts down. As for the long
+// poll, each batch of events counts as a listen with events, and the end of
+// the stream as a listen with its outcome.
+func (b *Broker) streamNDJSON(w http.ResponseWriter, r *http.Request, subscription *Subscription, timeout int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		b.sendError(w, r, 500, "streaming not supported")
+		return
+	}
+
+	// Attach this connection as listener, for the whole stream
+	signal := subscription.listen()
+	defer subscription.release(signal)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	deadline := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer deadline.Stop()
+
+	for {
+		select {
+
+		case st := <-signal:
+			switch st {
+			case stateReady:
+				events, dropped := subscription.takeEvents(0, 0)
+				if dropped > 0 {
+					if err := writeNDJSON(w, struct{ Dropped int }{dropped}); err != nil {
+						return
+					}
+				}
+				for _, e := range events {
+					if err := writeNDJSON(w, e.data()); err != nil {
+						return
+					}
+				}
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					return
+				}
+				b.metrics.listen(listenEvents)
+				b.hooks.delivered(subscription, events)
+			case stateAbort:
+				b.metrics.listen(listenAbort)
+				writeNDJSON(w, ErrorResponse{true, 500, "ABORTED"})
+				flusher.Flush()
+				return
+			case stateClosed:
+				b.metrics.listen(listenClosed)
+				writeNDJSON(w, ErrorResponse{true, 410, "subscription closed"})
+				flusher.Flush()
+				return
+			case stateOverflow:
+				b.metrics.listen(listenOverflow)
+				b.CloseSubscription(subscription)
+				writeNDJSON(w, ErrorResponse{true, 410, "subscription terminated: queue overflow"})
+				flusher.Flush()
+				return
+			case stateShutdown:
+				b.metrics.listen(listenShutdown)
+				writeNDJSON(w, b.shutdownResponse())
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+
+		// Timeout is triggered, the client reconnects
+		case <-deadline.C:
+			b.metrics.listen(listenTimeout)
+			b.hooks.listenTimedOut(subscription)
+			return
+
+		// The client went away
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeNDJSON writes an object as a JSON line. It returns the write error,
+// if the client went away.
+func writeNDJSON(w http.ResponseWriter, object interface{}) error {
+	json, err := toJSON(object)
+	if err != nil {
+		json = `{"Error":true,"ErrorCode":500,"Message":"can not encode event"}`
+	}
+	_, err = w.Write([]byte(json + "\n"))
+	return err
+}
@@ -0,0 +1,57 @@
+package lp
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamNDJSON(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger})
+	defer b.Close()
+	srv := httptest.NewServer(b.Handler())
+	defer srv.Close()
+
+	timedOut := make(chan *Subscription, 1)
+	b.OnListenTimeout(func(s *Subscription) { timedOut <- s })
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	s.Subscribe(feed)
+	b.NewEvent(feed, "a")
+	b.NewEvent(feed, "b")
+
+	// The stream ends at the timeout
+	resp, err := srv.Client().Get(srv.URL + "/listen?subscriptionID=" + s.ID() + "&stream=ndjson&timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"Payload":"a"`) || !strings.Contains(lines[1], `"Payload":"b"`) {
+		t.Fatalf("streamed %q, want the 2 events", body)
+	}
+	select {
+	case timed := <-timedOut:
+		if timed != s {
+			t.Fatal("timeout hook called with another subscription")
+		}
+	default:
+		t.Fatal("timeout hook not called")
+	}
+
+	var out bytes.Buffer
+	b.WriteMetrics(&out)
+	for _, want := range []string{
+		`lp_listen_requests_total{outcome="events"} 1`,
+		`lp_listen_requests_total{outcome="timeout"} 1`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+}
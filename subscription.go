@@ -3,57 +3,174 @@ package lp
 import (
 	"errors"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
-var subscriptions map[uuid]*Subscription
-
-func init() {
-	subscriptions = make(map[uuid]*Subscription)
-}
-
 // Subscription is the object that reppresent a connection
 type Subscription struct {
-	l         sync.Mutex
-	id        uuid
-	feeds     map[uuid]*Feed
-	listening bool
-	signal    chan state
-	events    []*Event
+	l          sync.Mutex
+	id         uuid
+	opts       SubscriptionOptions
+	defaults   SubscriptionOptions
+	feeds      map[uuid]*Feed
+	named      map[uuid]bool
+	patterns   map[string]bool
+	listener   chan state
+	events     []*Event
+	inflight   map[uint64]*inflightEvent
+	dropped    int
+	lastSeen   time.Time
+	closed     bool
+	overflowed bool
+	draining   bool
+	metrics    *metrics
+	hooks      *hooks
+}
+
+// SubscriptionOptions are the per subscription configuration parameters
+type SubscriptionOptions struct {
+	// MaxQueue is the maximum number of queued events. Zero falls back to
+	// the feed and then the broker defaults.
+	MaxQueue int
+	// Overflow is the policy applied when the queue is full
+	Overflow OverflowPolicy
+	// Ack enables the at-least-once delivery: delivered events must be
+	// acknowledged, otherwise they are delivered again
+	Ack bool
+	// AckTimeout is how long a delivered event waits to be acknowledged.
+	// Zero falls back to the broker default.
+	AckTimeout time.Duration
+	// Filter selects the events queued in the subscription. Nil queues all
+	// the events.
+	Filter *Filter
+	// Owner is the principal that created the subscription
+	Owner string
 }
 
 // NewSubscription tries to create a new connection object and returns it
-func NewSubscription() *Subscription {
-	id := newUUID()
+func (b *Broker) NewSubscription() *Subscription {
+	return b.NewSubscriptionWithOptions(SubscriptionOptions{})
+}
+
+// NewSubscriptionWithOptions tries to create a new connection object with
+// specific options and returns it
+func (b *Broker) NewSubscriptionWithOptions(opts SubscriptionOptions) *Subscription {
+	return b.newSubscription(b.newID(), opts)
+}
+
+// newSubscription creates a subscription with a given id
+func (b *Broker) newSubscription(id uuid, opts SubscriptionOptions) *Subscription {
 	s := new(Subscription)
 	s.id = id
+	s.opts = opts
+	s.defaults = SubscriptionOptions{
+		MaxQueue:   b.opts.MaxQueue,
+		Overflow:   b.opts.Overflow,
+		AckTimeout: b.opts.AckTimeout,
+	}
+	s.inflight = make(map[uint64]*inflightEvent)
 	s.feeds = make(map[uuid]*Feed)
-	s.signal = make(chan state)
+	s.named = make(map[uuid]bool)
+	s.patterns = make(map[string]bool)
 	s.events = make([]*Event, 0)
-	s.listening = false
-	subscriptions[s.id] = s
+	s.lastSeen = time.Now()
+	s.metrics = b.metrics
+	s.hooks = b.hooks
+
+	b.l.Lock()
+	if opts.Ack {
+		b.startRedeliverer()
+	}
+	s.draining = b.shuttingDown
+	b.subscriptions[s.id] = s
+	b.l.Unlock()
 	return s
 }
 
-// GetSubscription returns a connection object ptr, if exists
-func GetSubscription(id uuid) (*Subscription, error) {
-	var c *Subscription
-	var exists bool
+// GetSubscription returns a connection object ptr, if exists. A
+// subscription saved by the previous broker is restored on first use.
+func (b *Broker) GetSubscription(id uuid) (*Subscription, error) {
+	b.l.Lock()
+	c, exists := b.subscriptions[id]
+	state, restore := b.restored[id]
+	delete(b.restored, id)
+	b.l.Unlock()
 
-	if c, exists = subscriptions[id]; exists == false {
-		return c, errors.New("connection " + string(id) + " does not exists")
+	if exists {
+		return c, nil
+	}
+	if restore {
+		return b.restoreSubscription(state)
 	}
+	return c, errors.New("connection " + string(id) + " does not exists")
+}
 
-	return c, nil
+// CloseSubscription detaches a subscription from all its feeds, frees its
+// queue, removes it from the broker and wakes its listener
+func (b *Broker) CloseSubscription(s *Subscription) {
+	b.l.Lock()
+	delete(b.subscriptions, s.id)
+	b.l.Unlock()
+
+	s.l.Lock()
+	feeds := make([]*Feed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		feeds = append(feeds, f)
+	}
+	s.l.Unlock()
+	for _, f := range feeds {
+		s.Unsubscribe(f)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.closed = true
+	s.events = make([]*Event, 0)
+	s.inflight = make(map[uint64]*inflightEvent)
+	s.terminate(stateClosed)
+}
+
+// ID returns the subscription id
+func (s *Subscription) ID() string {
+	return string(s.id)
+}
+
+// FeedNames returns the names of the subscribed feeds
+func (s *Subscription) FeedNames() []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	names := make([]string, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Subscribe allows a connection to subscribe to a particular feed
 func (s *Subscription) Subscribe(feed *Feed) error {
+	return s.subscribe(feed, true)
+}
+
+// subscribe adds a feed to the subscription. The feeds subscribed by name
+// stay subscribed when the patterns matching them are removed.
+func (s *Subscription) subscribe(feed *Feed, byName bool) error {
 	err := feed.addSubscription(s)
 	if err != nil {
 		return err
 	}
+	s.l.Lock()
 	s.feeds[feed.id] = feed
+	if byName {
+		s.named[feed.id] = true
+	}
+	s.l.Unlock()
+
+	s.hooks.subscribed(s, feed)
 	return nil
 }
 
@@ -63,7 +180,15 @@ func (s *Subscription) Unsubscribe(feed *Feed) error {
 	if err != nil {
 		return err
 	}
+	s.l.Lock()
+	_, subscribed := s.feeds[feed.id]
 	delete(s.feeds, feed.id)
+	delete(s.named, feed.id)
+	s.l.Unlock()
+
+	if subscribed {
+		s.hooks.unsubscribed(s, feed)
+	}
 	return nil
 }
 
@@ -72,16 +197,22 @@ func (s *Subscription) NotifyEvent(e *Event) {
 	s.l.Lock()
 	defer s.l.Unlock()
 
-	s.events = append(s.events, e)
+	// A terminated subscription waits to be closed
+	if s.overflowed {
+		return
+	}
 
-	// If a listener is connected, notify an event is ready
-	if s.listening {
-		s.signal <- stateReady
+	if !s.enqueue(e) {
+		s.terminate(stateOverflow)
+		return
 	}
+
+	// If a listener is connected, notify an event is ready
+	s.signal(stateReady)
 }
 
 // CheckForEvents checks if there are events in the subscriber queue,
-// in case send them in the communicationChannel
+// in case notify the listener
 func (s *Subscription) CheckForEvents() {
 	s.l.Lock()
 	defer s.l.Unlock()
@@ -90,27 +221,129 @@ func (s *Subscription) CheckForEvents() {
 		return
 	}
 
-	s.signal <- stateReady
+	s.signal(stateReady)
+}
+
+// listen attaches a new listener to the subscription, aborting the previous
+// one, and returns the channel where the listener receives the signals
+func (s *Subscription) listen() chan state {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	// Send an abort signal to previous listening connection
+	if s.listener != nil {
+		s.terminate(stateAbort)
+	}
+
+	s.listener = make(chan state, 1)
+	s.lastSeen = time.Now()
+
+	if s.closed {
+		s.listener <- stateClosed
+	} else if s.overflowed {
+		s.listener <- stateOverflow
+	} else if s.draining {
+		s.listener <- stateShutdown
+	} else if len(s.events) > 0 {
+		s.listener <- stateReady
+	}
+	return s.listener
+}
+
+// release detaches a listener, if it is still the active one
+func (s *Subscription) release(listener chan state) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.listener == listener {
+		s.listener = nil
+	}
+	s.lastSeen = time.Now()
+}
+
+// signal notifies the active listener, without blocking. A pending signal
+// is enough to wake the listener, so a new one is dropped.
+// It must be called holding the subscription lock.
+func (s *Subscription) signal(st state) {
+	if s.listener == nil {
+		return
+	}
+	select {
+	case s.listener <- st:
+	default:
+	}
+}
+
+// terminate replaces any pending signal of the active listener with st and
+// detaches it. It must be called holding the subscription lock.
+func (s *Subscription) terminate(st state) {
+	if s.listener == nil {
+		return
+	}
+	select {
+	case <-s.listener:
+	default:
+	}
+	s.listener <- st
+	s.listener = nil
 }
 
 // GetEvents returns the events for this subscription
 func (s *Subscription) GetEvents() []*Event {
+	events, _ := s.takeEvents(0, 0)
+	return events
+}
+
+// takeEvents returns the events for this subscription and the number of
+// events dropped since the previous call. At most maxEvents events and
+// maxBytes bytes (JSON encoded) are returned, if they are not zero; at least
+// one event is always returned. The other events stay in the queue.
+func (s *Subscription) takeEvents(maxEvents int, maxBytes int) ([]*Event, int) {
 	s.l.Lock()
 	defer s.l.Unlock()
 
 	events := make([]*Event, 0)
+	dropped := s.dropped
+	s.dropped = 0
 	if len(s.events) == 0 {
-		return events
+		return events, dropped
 	}
 
+	size := 0
 	for _, e := range s.events {
+		if maxEvents > 0 && len(events) >= maxEvents {
+			break
+		}
+		if maxBytes > 0 {
+			if json, err := toJSON(e.data()); err == nil {
+				size += len(json)
+			}
+			if size > maxBytes && len(events) > 0 {
+				break
+			}
+		}
 		events = append(events, e)
 	}
 
-	// Clean the list for this subscription
-	s.events = make([]*Event, 0)
+	// Clean the list for this subscription, keeping the events not taken
+	s.events = append(make([]*Event, 0), s.events[len(events):]...)
 
-	return events
+	// In ack mode the events wait to be acknowledged
+	if s.opts.Ack {
+		s.track(events)
+	}
+
+	s.metrics.eventsDelivered(events, time.Now())
+
+	return events, dropped
+}
+
+// queued returns the number of queued events
+func (s *Subscription) queued() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return len(s.events)
 }
 
 func (s *Subscription) String() string {
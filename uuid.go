@@ -1,36 +1,73 @@
 package lp
 
-import "sync"
-
-const uuidLenght = 32
+import (
+	"crypto/rand"
+	"time"
+)
 
 type uuid string
 
-var uuids map[uuid]bool
-var uuidLock sync.Mutex
+// IDGenerator generates the ids of the feeds, the subscriptions and the
+// events. The ids must be unique; subscription ids must not be guessable.
+type IDGenerator interface {
+	NewID() string
+}
+
+// ulidEncoding is the Crockford base32 alphabet
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
 
-func init() {
-	uuids = make(map[uuid]bool)
+// ULIDGenerator is the default IDGenerator. It returns ULIDs: 26 characters
+// encoding a millisecond timestamp and 80 random bits (crypto/rand), so the
+// ids are sorted by creation time, up to the millisecond. The random bits
+// are drawn for every id: the ids generated in the same millisecond are not
+// sorted, but an id tells nothing about the others.
+type ULIDGenerator struct{}
+
+// NewULIDGenerator returns a new ULIDGenerator
+func NewULIDGenerator() *ULIDGenerator {
+	return new(ULIDGenerator)
 }
 
-func newUUID() uuid {
-	uuidLock.Lock()
-	defer uuidLock.Unlock()
+// NewID returns a new ULID. It panics if the system random source fails.
+func (g *ULIDGenerator) NewID() string {
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
 
-	b := make([]byte, uuidLenght)
-	var id uuid
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	for true {
-		for i := range b {
-			b[i] = charset[seededRand.Intn(len(charset))]
-		}
-		id = uuid(b)
-		if _, exists := uuids[id]; exists == false {
-			uuids[id] = true
-			break
+	var data [16]byte
+	for i := 0; i < 6; i++ {
+		data[i] = byte(ms >> uint(40-8*i))
+	}
+	if _, err := rand.Read(data[6:]); err != nil {
+		panic("lp: can not read random bytes: " + err.Error())
+	}
+	return encodeULID(data)
+}
+
+// encodeULID encodes 128 bits in 26 base32 characters (the first one
+// carries only 3 bits)
+func encodeULID(data [16]byte) string {
+	out := make([]byte, 26)
+
+	// Process the 128 bits from the least significant one, 5 bits at time
+	pos := 25
+	var buffer uint32
+	var n uint
+	for i := len(data) - 1; i >= 0; i-- {
+		buffer |= uint32(data[i]) << n
+		n += 8
+		for n >= 5 {
+			out[pos] = ulidEncoding[buffer&0x1F]
+			pos--
+			buffer >>= 5
+			n -= 5
 		}
 	}
-	return id
+	out[pos] = ulidEncoding[buffer&0x1F]
+	return string(out)
+}
+
+// newID returns a new id from the broker generator
+func (b *Broker) newID() uuid {
+	return uuid(b.ids.NewID())
 }
 
 func (u uuid) String() string {
@@ -0,0 +1,71 @@
+package lp
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestULIDGenerator(t *testing.T) {
+	g := NewULIDGenerator()
+	seen := make(map[string]bool)
+	previous := ""
+	for i := 0; i < 1000; i++ {
+		id := g.NewID()
+		if len(id) != 26 || strings.Trim(id, ulidEncoding) != "" {
+			t.Fatalf("not valid ULID %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicated id %s", id)
+		}
+		seen[id] = true
+
+		// The timestamp prefix never goes back
+		if id[:10] < previous {
+			t.Fatalf("id %s generated after %s", id, previous)
+		}
+		previous = id[:10]
+	}
+
+	// Ids of different milliseconds are sorted
+	first := g.NewID()
+	time.Sleep(2 * time.Millisecond)
+	if second := g.NewID(); second <= first {
+		t.Fatalf("id %s not after %s", second, first)
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var data [16]byte
+	if id := encodeULID(data); id != strings.Repeat("0", 26) {
+		t.Fatalf("zero encoded as %s", id)
+	}
+	for i := range data {
+		data[i] = 0xFF
+	}
+	if id := encodeULID(data); id != "7"+strings.Repeat("Z", 25) {
+		t.Fatalf("max encoded as %s", id)
+	}
+}
+
+// sequentialIDs is an IDGenerator returning predictable ids
+type sequentialIDs struct {
+	next int
+}
+
+func (g *sequentialIDs) NewID() string {
+	g.next++
+	return "id-" + strconv.Itoa(g.next)
+}
+
+func TestIDGeneratorOption(t *testing.T) {
+	b := NewBroker(Options{Logger: DiscardLogger, IDGenerator: &sequentialIDs{}})
+	defer b.Close()
+
+	feed, _ := b.NewFeed("f")
+	s := b.NewSubscription()
+	if feed.id != "id-1" || s.ID() != "id-2" {
+		t.Fatalf("ids %s %s, want the generator ones", feed.id, s.ID())
+	}
+}
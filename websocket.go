@@ -0,0 +1,300 @@
+package lp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 implementation: handshake, framing, fragmentation and
+// control frames. Extensions and subprotocols are not supported.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxMessage is the maximum size of a message sent by a client
+const websocketMaxMessage = 1 << 20
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close status codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsCloseTooBig        = 1009
+	wsCloseInternalError = 1011
+)
+
+var errWebSocketClosed = errors.New("websocket closed")
+
+// wsConn is a server side websocket connection
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	wl     sync.Mutex
+	closed bool
+	// writeTimeout is the write deadline of each frame, if not zero
+	writeTimeout time.Duration
+}
+
+// upgradeWebSocket validates the opening handshake and hijacks the
+// connection. Once the connection is hijacked, the HTTP response can not be
+// used anymore: if the handshake reply fails, the connection is closed and
+// errWebSocketClosed is returned.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" {
+		return nil, errors.New("websocket handshake requires GET")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can not be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, errWebSocketClosed
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value of a key
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContainsToken checks if a comma separated header contains a token
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header[name] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message. Pings are answered,
+// pongs are ignored. A close frame is echoed and errWebSocketClosed is
+// returned.
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var opcode int
+	message := make([]byte, 0)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload[0:2]))
+			}
+			c.close(code, "")
+			return 0, nil, errWebSocketClosed
+		case wsText, wsBinary:
+			if opcode != 0 {
+				c.close(wsCloseProtocolError, "unexpected data frame")
+				return 0, nil, errors.New("unexpected data frame")
+			}
+			opcode = op
+		case wsContinuation:
+			if opcode == 0 {
+				c.close(wsCloseProtocolError, "unexpected continuation frame")
+				return 0, nil, errors.New("unexpected continuation frame")
+			}
+		default:
+			c.close(wsCloseProtocolError, "unknown opcode")
+			return 0, nil, errors.New("unknown opcode")
+		}
+
+		if len(message)+len(payload) > websocketMaxMessage {
+			c.close(wsCloseTooBig, "message too big")
+			return 0, nil, errors.New("message too big")
+		}
+		message = append(message, payload...)
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload
+func (c *wsConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(c.rw, header); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		c.close(wsCloseProtocolError, "reserved bits set")
+		return fin, 0, nil, errors.New("reserved bits set")
+	}
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	// Clients must mask their frames
+	if !masked {
+		c.close(wsCloseProtocolError, "frame not masked")
+		return fin, opcode, nil, errors.New("frame not masked")
+	}
+
+	// Control frames can not be fragmented and have small payloads
+	if opcode >= wsClose && (!fin || length > 125) {
+		c.close(wsCloseProtocolError, "invalid control frame")
+		return fin, opcode, nil, errors.New("invalid control frame")
+	}
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err = io.ReadFull(c.rw, extended); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err = io.ReadFull(c.rw, extended); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > websocketMaxMessage {
+		c.close(wsCloseTooBig, "message too big")
+		return fin, opcode, nil, errors.New("message too big")
+	}
+
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(c.rw, mask); err != nil {
+		return
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single unmasked frame. It is thread safe.
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+
+	if c.closed {
+		return errWebSocketClosed
+	}
+	return c.write(opcode, payload)
+}
+
+// write writes a frame, so a client that does not read can not block the
+// writer longer than the write timeout. It must be called holding the write
+// lock.
+func (c *wsConn) write(opcode int, payload []byte) error {
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
+
+	header := []byte{0x80 | byte(opcode), 0}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// writeText sends a text message
+func (c *wsConn) writeText(text string) error {
+	return c.writeFrame(wsText, []byte(text))
+}
+
+// close sends a close frame (once) and closes the connection
+func (c *wsConn) close(code int, reason string) {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	c.write(wsClose, payload)
+	c.conn.Close()
+}
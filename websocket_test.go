@@ -0,0 +1,194 @@
+package lp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWebSocket returns a server side connection and the client end of
+// the pipe. What the server writes is read and discarded.
+func newTestWebSocket(t *testing.T) (*wsConn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	conn := &wsConn{
+		conn: server,
+		rw:   bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)),
+	}
+	return conn, client
+}
+
+// clientFrame encodes a masked frame, with a declared length that can
+// differ from the payload one
+func clientFrame(fin bool, opcode int, length uint64, payload []byte) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, 0}
+	switch {
+	case length <= 125:
+		frame[1] = byte(length)
+	case length <= 0xFFFF:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, length)
+	}
+	frame[1] |= 0x80
+
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+// sendFrames writes the frames from the client, then reads the server
+// replies until the pipe is closed
+func sendFrames(client net.Conn, frames ...[]byte) {
+	go func() {
+		for _, frame := range frames {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(io.Discard, client)
+}
+
+func TestWebSocketReadMessage(t *testing.T) {
+	conn, client := newTestWebSocket(t)
+	sendFrames(client,
+		clientFrame(false, wsText, 5, []byte("hello")),
+		clientFrame(true, wsPing, 2, []byte("hi")),
+		clientFrame(true, wsContinuation, 6, []byte(" world")),
+	)
+
+	opcode, message, err := conn.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != wsText || string(message) != "hello world" {
+		t.Fatalf("read %d %q, want a text message \"hello world\"", opcode, message)
+	}
+}
+
+func TestWebSocketFrameLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		err    string
+	}{
+		{
+			"frame too big",
+			[][]byte{clientFrame(true, wsText, websocketMaxMessage+1, nil)},
+			"message too big",
+		},
+		{
+			"message too big",
+			[][]byte{
+				clientFrame(false, wsBinary, websocketMaxMessage/2+1, make([]byte, websocketMaxMessage/2+1)),
+				clientFrame(true, wsContinuation, websocketMaxMessage/2+1, make([]byte, websocketMaxMessage/2+1)),
+			},
+			"message too big",
+		},
+		{
+			"not masked",
+			[][]byte{{0x81, 0x01, 'a'}},
+			"frame not masked",
+		},
+		{
+			"reserved bits",
+			[][]byte{{0xC1, 0x81, 1, 2, 3, 4, 'a'}},
+			"reserved bits set",
+		},
+		{
+			"fragmented control frame",
+			[][]byte{clientFrame(false, wsPing, 1, []byte("a"))},
+			"invalid control frame",
+		},
+		{
+			"control frame too big",
+			[][]byte{clientFrame(true, wsPing, 126, make([]byte, 126))},
+			"invalid control frame",
+		},
+		{
+			"unexpected continuation",
+			[][]byte{clientFrame(true, wsContinuation, 1, []byte("a"))},
+			"unexpected continuation frame",
+		},
+		{
+			"unexpected data frame",
+			[][]byte{
+				clientFrame(false, wsText, 1, []byte("a")),
+				clientFrame(true, wsText, 1, []byte("b")),
+			},
+			"unexpected data frame",
+		},
+		{
+			"unknown opcode",
+			[][]byte{clientFrame(true, 0x3, 1, []byte("a"))},
+			"unknown opcode",
+		},
+	}
+
+	for _, test := range tests {
+		conn, client := newTestWebSocket(t)
+		sendFrames(client, test.frames...)
+
+		_, _, err := conn.readMessage()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		if !conn.closed {
+			t.Errorf("%s: connection not closed", test.name)
+		}
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	conn, client := newTestWebSocket(t)
+	sendFrames(client, clientFrame(true, wsClose, 2, []byte{0x03, 0xE8}))
+
+	if _, _, err := conn.readMessage(); err != errWebSocketClosed {
+		t.Fatalf("error %v, want %v", err, errWebSocketClosed)
+	}
+	if err := conn.writeText("late"); err != errWebSocketClosed {
+		t.Fatalf("write after close: %v, want %v", err, errWebSocketClosed)
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// Example of RFC 6455, section 1.3
+	if accept := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("accept %s", accept)
+	}
+}
+
+func TestWebSocketWriteTimeout(t *testing.T) {
+	conn, _ := newTestWebSocket(t)
+	conn.writeTimeout = 50 * time.Millisecond
+
+	// The client does not read
+	done := make(chan error, 1)
+	go func() { done <- conn.writeText("hello") }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("write to a client not reading succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked after the write timeout")
+	}
+}
@@ -0,0 +1,295 @@
+package lp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wsRequest is a message sent by a websocket client. Ref is echoed in the
+// reply, so the client can match replies and requests.
+//
+//	{"Type": "subscribe", "Feeds": ["feed1", "feed2"]}
+//	{"Type": "unsubscribe", "Feeds": ["feed2"]}
+//	{"Type": "publish", "Feed": "feed1", "Payload": {...}}
+type wsRequest struct {
+	Type    string
+	Ref     string
+	Feeds   []string
+	Feed    string
+	Payload json.RawMessage
+}
+
+// wsMessage is a message sent to a websocket client. Type is one of
+// "subscribed", "ok", "error", "event", "dropped" and "shutdown" (Redirect is
+// the node to reconnect to, if any).
+type wsMessage struct {
+	Type           string
+	Ref            string     `json:",omitempty"`
+	SubscriptionID string     `json:",omitempty"`
+	Feeds          []string   `json:",omitempty"`
+	Patterns       []string   `json:",omitempty"`
+	Message        string     `json:",omitempty"`
+	Event          *EventData `json:",omitempty"`
+	Dropped        int        `json:",omitempty"`
+	Redirect       string     `json:",omitempty"`
+}
+
+// WebSocketHandler upgrades the connection to a websocket. Each connection
+// owns a subscription (to the feed parameters, if any, filtered by the
+// filter parameter) that is closed with the connection. The client
+// subscribes, unsubscribes and publishes events sending JSON messages and
+// receives the events as JSON messages.
+func (b *Broker) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Send an internal error in case of panic.
+	defer b.sendInternalError(w, r)
+
+	// Browsers send the credentials of any page opening a websocket
+	if !b.checkOrigin(r) {
+		b.sendError(w, r, 403, "origin not allowed")
+		return
+	}
+
+	feeds := b.getFeeds(r)
+	patterns, err := extractPatterns(r)
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+
+	// The connection subscribes and listens
+	if !b.authorize(w, r, ActionSubscribe, extractFeeds(r)) ||
+		!b.authorize(w, r, ActionListen, extractFeeds(r)) {
+		return
+	}
+
+	// No new subscriptions during the shutdown
+	if b.isShuttingDown() {
+		b.sendShutdown(w, r)
+		return
+	}
+
+	// Bind the subscription to its creator
+	opts := SubscriptionOptions{Owner: b.principal(r)}
+	if b.opts.BindSubscriptions && opts.Owner == "" {
+		b.sendError(w, r, 401, "subscriptions are bound to their creator, the client must be identified")
+		return
+	}
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		if opts.Filter, err = CompileFilter(filter); err != nil {
+			b.sendError(w, r, 400, err.Error())
+			return
+		}
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err == errWebSocketClosed {
+		return
+	}
+	if err != nil {
+		b.sendError(w, r, 400, err.Error())
+		return
+	}
+	conn.writeTimeout = b.opts.Heartbeat
+
+	// The connection is hijacked, errors are reported with a close frame
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			b.logger.Error("handler panic", append([]interface{}{"panic", recovered}, requestFields(r)...)...)
+			conn.close(wsCloseInternalError, "internal server error")
+		}
+	}()
+
+	subscription := b.NewSubscriptionWithOptions(opts)
+	for _, feed := range feeds {
+		subscription.Subscribe(feed)
+	}
+	for _, pattern := range patterns {
+		b.SubscribePattern(subscription, pattern)
+	}
+	defer b.CloseSubscription(subscription)
+
+	conn.send(wsMessage{
+		Type:           "subscribed",
+		SubscriptionID: string(subscription.id),
+		Feeds:          subscription.FeedNames(),
+		Patterns:       subscription.Patterns(),
+	})
+
+	// Events are written by a dedicated goroutine
+	go b.wsDeliver(conn, subscription)
+
+	for {
+		opcode, data, err := conn.readMessage()
+		if err != nil {
+			conn.close(wsCloseGoingAway, "")
+			return
+		}
+		if opcode != wsText {
+			conn.close(wsCloseUnsupported, "text messages only")
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			conn.send(wsMessage{Type: "error", Message: "can not parse message"})
+			continue
+		}
+		conn.send(b.wsHandleRequest(r, subscription, req))
+	}
+}
+
+// checkOrigin returns true if the Origin of a websocket handshake is
+// allowed (see Options.AllowedOrigins)
+func (b *Broker) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(b.opts.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range b.opts.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsHandleRequest executes a client request and returns the reply
+func (b *Broker) wsHandleRequest(r *http.Request, subscription *Subscription, req wsRequest) wsMessage {
+	switch req.Type {
+
+	case "subscribe", "unsubscribe":
+		if len(req.Feeds) == 0 {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: "missing valid feed(s)"}
+		}
+		if err := b.checkAuthorization(r, ActionSubscribe, req.Feeds); err != nil {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+		}
+		if req.Type == "subscribe" {
+			if err := b.checkAuthorization(r, ActionListen, req.Feeds); err != nil {
+				return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+			}
+		}
+		for _, feedName := range req.Feeds {
+			if IsFeedPattern(feedName) {
+				var err error
+				if req.Type == "subscribe" {
+					err = b.SubscribePattern(subscription, feedName)
+				} else {
+					err = b.UnsubscribePattern(subscription, feedName)
+				}
+				if err != nil {
+					return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+				}
+				continue
+			}
+			feed, err := b.GetFeedFromName(feedName)
+			if err != nil {
+				return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+			}
+			if req.Type == "subscribe" {
+				subscription.Subscribe(feed)
+			} else {
+				subscription.Unsubscribe(feed)
+			}
+		}
+		return wsMessage{Type: "ok", Ref: req.Ref, Feeds: subscription.FeedNames(), Patterns: subscription.Patterns()}
+
+	case "publish":
+		if err := b.checkAuthorization(r, ActionPublish, []string{req.Feed}); err != nil {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+		}
+		feed, err := b.GetFeedFromName(req.Feed)
+		if err != nil {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: err.Error()}
+		}
+		if ok, _ := b.limitPublish(r, feed); !ok {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: "rate limit exceeded"}
+		}
+		payload, err := b.parseEvent(string(req.Payload))
+		if err != nil {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: "missing event parser function"}
+		}
+		if _, err := b.NewEvent(feed, payload); err != nil {
+			return wsMessage{Type: "error", Ref: req.Ref, Message: fmt.Sprintf("%s", err)}
+		}
+		return wsMessage{Type: "ok", Ref: req.Ref}
+	}
+
+	return wsMessage{Type: "error", Ref: req.Ref, Message: "unknown message type " + req.Type}
+}
+
+// wsDeliver writes the subscription events to the websocket until the
+// subscription is closed or the connection fails. Each batch of events
+// counts as a listen with events, and the end of the delivery as a listen
+// with its outcome.
+func (b *Broker) wsDeliver(conn *wsConn, subscription *Subscription) {
+	signal := subscription.listen()
+	defer subscription.release(signal)
+
+	heartbeat := time.NewTicker(b.opts.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+
+		case st := <-signal:
+			switch st {
+			case stateReady:
+				events, dropped := subscription.takeEvents(0, 0)
+				if dropped > 0 {
+					if err := conn.send(wsMessage{Type: "dropped", Dropped: dropped}); err != nil {
+						return
+					}
+				}
+				for _, e := range events {
+					data := e.data()
+					if err := conn.send(wsMessage{Type: "event", Event: &data}); err != nil {
+						return
+					}
+				}
+				b.metrics.listen(listenEvents)
+				b.hooks.delivered(subscription, events)
+			case stateAbort:
+				b.metrics.listen(listenAbort)
+				conn.close(wsCloseNormal, "ABORTED")
+				return
+			case stateClosed:
+				b.metrics.listen(listenClosed)
+				conn.close(wsCloseNormal, "subscription closed")
+				return
+			case stateOverflow:
+				b.metrics.listen(listenOverflow)
+				conn.close(wsCloseNormal, "subscription terminated: queue overflow")
+				return
+			case stateShutdown:
+				b.metrics.listen(listenShutdown)
+				conn.send(wsMessage{Type: "shutdown", Message: ShutdownMessage, Redirect: b.opts.ShutdownRedirect})
+				conn.close(wsCloseGoingAway, "server shutting down")
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := conn.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes a JSON message
+func (c *wsConn) send(message wsMessage) error {
+	json, err := toJSON(message)
+	if err != nil {
+		return err
+	}
+	return c.writeText(json)
+}